@@ -0,0 +1,34 @@
+package ocrpdf
+
+// #include "tesseract/capi.h"
+// #include <stdint.h>
+//
+// extern bool goTessCancel(void *cancel_this, int words);
+//
+// static void setCancelHandle(ETEXT_DESC *monitor, uintptr_t handle) {
+// 	TessMonitorSetCancelFunc(monitor, goTessCancel);
+// 	TessMonitorSetCancelThis(monitor, (void *)handle);
+// }
+import "C"
+import (
+	"context"
+	"runtime/cgo"
+)
+
+// newCancelMonitor returns a Tesseract progress monitor that cancels
+// recognition once ctx is done, and a function that frees it, which must be
+// called once recognition has finished. Returns a nil monitor if ctx can't
+// be cancelled.
+func newCancelMonitor(ctx context.Context) (*C.ETEXT_DESC, func()) {
+	if ctx.Done() == nil {
+		return nil, func() {}
+	}
+
+	monitor := C.TessMonitorCreate()
+	handle := cgo.NewHandle(ctx)
+	C.setCancelHandle(monitor, C.uintptr_t(handle))
+	return monitor, func() {
+		C.TessMonitorDelete(monitor)
+		handle.Delete()
+	}
+}
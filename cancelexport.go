@@ -0,0 +1,19 @@
+package ocrpdf
+
+// #include <stdbool.h>
+import "C"
+import (
+	"context"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// goTessCancel is called periodically by Tesseract during recognition, with
+// the handle of the context given to newCancelMonitor, and returns true to
+// cancel recognition once the context is done.
+//
+//export goTessCancel
+func goTessCancel(cancelThis unsafe.Pointer, words C.int) C.bool {
+	ctx := cgo.Handle(uintptr(cancelThis)).Value().(context.Context)
+	return C.bool(ctx.Err() != nil)
+}
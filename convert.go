@@ -0,0 +1,106 @@
+package ocrpdf
+
+import (
+	"context"
+	"fmt"
+	"io"
+)
+
+// Convert creates a searchable document from the images in the given files,
+// writing it to out. Each file adds a page for each of its images (see
+// ImageCount), which is prepared as per the document settings and the EXIF
+// orientation of the file, and has its text recognised with a Tess instance
+// created from the DataPath and Language of opts. If progress isn't nil, it
+// is called after each page is added, with the number of pages added so far
+// and the total number of pages. If ctx is cancelled, conversion stops as
+// soon as possible (abandoning the recognition of the current page) and
+// ctx.Err() is returned, with nothing written to out. For example:
+//
+//	err := Convert(ctx, DefaultOptions(), []string{"scan1.png", "scan2.tif"},
+//		w, func(page, total int) {
+//			fmt.Printf("%d/%d\n", page, total)
+//		})
+func Convert(ctx context.Context, opts Options, inputs []string,
+	out io.Writer, progress func(page, total int)) error {
+	// Count the pages first, so progress can be given against the total
+	counts := make([]int, len(inputs))
+	total := 0
+	for i, filename := range inputs {
+		n, err := ImageCount(filename)
+		if err != nil {
+			return err
+		}
+		counts[i] = n
+		total += n
+	}
+
+	tess, err := NewTess(opts.DataPath, opts.Language)
+	if err != nil {
+		return err
+	}
+	defer tess.Close()
+
+	doc := NewDocumentWithOptions(opts.PageSize, WithOptions(opts))
+	if err := doc.Error(); err != nil {
+		return err
+	}
+
+	page := 0
+	for i, filename := range inputs {
+		for index := 0; index < counts[i]; index++ {
+			name := filename
+			if counts[i] > 1 {
+				name = fmt.Sprintf("%s#%d", filename, index+1)
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := convertPage(ctx, doc, tess, filename, index,
+				name); err != nil {
+				return err
+			}
+			page++
+			if progress != nil {
+				progress(page, total)
+			}
+		}
+	}
+
+	return doc.Write(out)
+}
+
+// convertPage recognises the text of the given image of a file, and adds it
+// to the document as a page with the given (unique) name.
+func convertPage(ctx context.Context, doc *Document, tess *Tess,
+	filename string, index int, name string) error {
+	img, err := NewImageFromFileIndex(filename, index)
+	if err != nil {
+		return err
+	}
+	defer img.Close()
+
+	prepared := doc.PrepareImage(img)
+	if prepared != img {
+		defer prepared.Close()
+	}
+
+	// Text is recognised upright, whilst the image is stored as it is
+	rotation, _ := EXIFRotation(filename)
+	ocrImg := prepared.RotateOrth(rotation / 90)
+	if ocrImg != prepared {
+		defer ocrImg.Close()
+	}
+
+	tess.Clear()
+	tess.SetImagePix(ocrImg.CPIX())
+	words, err := tess.WordsContext(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return err
+		}
+		return fmt.Errorf("could not recognise text in '%s': %s", name, err)
+	}
+
+	doc.SetPageRotation(rotation)
+	return doc.AddPage(*prepared, name, words, "")
+}
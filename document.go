@@ -1,6 +1,16 @@
 package ocrpdf
 
-import "github.com/jung-kurt/gofpdf"
+import (
+	"io"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jung-kurt/gofpdf"
+)
 
 // Orientation defines page orientations
 type Orientation string
@@ -27,48 +37,454 @@ const (
 	MatchTextScaling = "match"
 )
 
+// ImageFit defines the ways in which images are placed on their pages
+type ImageFit string
+
+const (
+	// PageImageFit shrinks the image to fit the page, and then the page to
+	// fit the image, so the image fills the page without distortion.
+	PageImageFit ImageFit = "page"
+	// FitImageFit shrinks (or enlarges) the image to fit the page, keeping
+	// its aspect ratio, and centres it, leaving space around it.
+	FitImageFit = "fit"
+	// FillImageFit enlarges the image to cover the page, keeping its aspect
+	// ratio, and centres it, cutting off any of it beyond the page.
+	FillImageFit = "fill"
+	// CenterImageFit centres the image at its actual size (see
+	// SetImageFit), cutting off any of it beyond the page.
+	CenterImageFit = "center"
+	// ActualImageFit places the image at its actual size in the top left
+	// corner of the page, cutting off any of it beyond the page.
+	ActualImageFit = "actual"
+)
+
+// TextLayout defines the ways in which the text of words is added to pages
+type TextLayout string
+
+const (
+	// CellTextLayout adds each word separately, scaled to fit its box.
+	CellTextLayout TextLayout = "cell"
+	// LineRunTextLayout adds each line of words as a single run of text,
+	// which some viewers select and copy more reliably.
+	LineRunTextLayout = "line-run"
+	// LinesTextLayout adds the lines of words of each page, in reading
+	// order, as a single block of text with a line break between lines, so
+	// that copied text keeps its lines.
+	LinesTextLayout = "lines"
+)
+
+// mmPerInch is the number of document units (millimetres) per inch.
+const mmPerInch = 25.4
+
+// maxPageLength is the length of the longest page edge, in millimetres, that
+// viewers are expected to support (200in).
+const maxPageLength = 5080
+
+// DefaultFitAspectRatio is the default aspect ratio (of the long edge to the
+// short edge) above which pages are sized to fit the image when fitting to
+// aspect ratio is enabled.
+const DefaultFitAspectRatio = 2.0
+
+// minWordAngle is the smallest baseline angle, in degrees, for which text is
+// rotated when word rotation is enabled.
+const minWordAngle = 0.5
+
+// PDF text rendering modes, used to draw text that is never painted.
+const (
+	fillTextMode      = 0
+	invisibleTextMode = 3
+)
+
+// ImageHook receives the image data embedded in a page, exactly as stored in
+// the document, along with its format ("jpg" or "png").
+type ImageHook func(page int, data []byte, format string)
+
 // Document is a wrapped version of gofpdf.Fpd which adds additional methods
 // for constructing documents with OCR-generated text.
 type Document struct {
 	*gofpdf.Fpdf
-	ocrLayerID  int
-	scanLayerID int
-	debug       bool
-	orientation Orientation
-	textScaling TextScaling
+	ocrLayerID     int
+	scanLayerID    int
+	pageLayers     map[int][]int
+	debug          bool
+	orientation    Orientation
+	textScaling    TextScaling
+	textLayout     TextLayout
+	rotateWords    bool
+	minConfidence  float32
+	autoFontSize   bool
+	imageHook      ImageHook
+	imageFormat    string
+	encodeOptions  EncodeOptions
+	contrast       float64
+	dpi            int
+	sourceDPI      int
+	smoothScaling  bool
+	fitAspect      float64
+	boxPadding     float64
+	margins        pageMargins
+	imageFit       ImageFit
+	encrypted      bool
+	pageRotation   int
+	fontFamily     string
+	fontStyle      string
+	unicodeFont    bool
+	preserveStyles bool
 }
 
-// NewDocument returns a new Document of the specified size.
+// NewDocument returns a new Document of the specified size, which is either
+// a name known to gofpdf (such as "A4" or "Letter"), or explicit dimensions
+// (see ParsePageSize). Invalid sizes set the document's error.
 func NewDocument(size string) *Document {
-	pdf := gofpdf.New("P", "mm", size, "")
+	pdf := newFpdf(size)
 	pdf.SetAutoPageBreak(false, 0)
 	pdf.SetCellMargin(0)
+	pdf.SetDisplayMode("fullpage", "single")
 	ocrLayerID := pdf.AddLayer("OCR", true)
 	scanLayerID := pdf.AddLayer("Scan", true)
 	return &Document{
 		Fpdf:        pdf,
 		ocrLayerID:  ocrLayerID,
 		scanLayerID: scanLayerID,
+		pageLayers:  make(map[int][]int),
 	}
 }
 
+// OCRLayerID returns the ID of the layer containing recognised text.
+func (d *Document) OCRLayerID() int {
+	return d.ocrLayerID
+}
+
+// ScanLayerID returns the ID of the layer containing scanned images.
+func (d *Document) ScanLayerID() int {
+	return d.scanLayerID
+}
+
+// LayerOrder returns the IDs of the layers drawn on the given page (numbered
+// from 1) by AddPage, in the order they were drawn, such that later layers
+// appear on top of earlier ones.
+func (d *Document) LayerOrder(page int) []int {
+	return d.pageLayers[page]
+}
+
 // SetTextScaling enables the scaling of embedded text such that it matches
 // the same area that the original text was detected.
 func (d *Document) SetTextScaling(mode TextScaling) {
 	d.textScaling = mode
 }
 
+// SetSourceDPI sets the resolution, in DPI, of the images given to AddPage
+// (0 = unknown). Words are normally positioned in image pixels, within a
+// transformation that scales them to the page, so the size of text (before
+// text scaling) depends on the resolution of the image. When the resolution
+// is known, words are instead positioned in page units, so the text layer is
+// the same whatever the resolution, and needs no transformation at all if
+// the page is the same size as the image.
+func (d *Document) SetSourceDPI(dpi int) {
+	d.sourceDPI = dpi
+}
+
+// pixelSize returns the size of an image pixel in page units (millimetres),
+// according to the source DPI, or 1 if it is unknown.
+func (d *Document) pixelSize() float64 {
+	if d.sourceDPI <= 0 {
+		return 1
+	}
+	return mmPerInch / float64(d.sourceDPI)
+}
+
+// SetMinConfidence sets the minimum confidence (0-100) of the words that
+// AddPage adds to the text layer, so that junk recognised in noisy scans is
+// left out. Empty words are always left out.
+func (d *Document) SetMinConfidence(confidence float32) {
+	d.minConfidence = confidence
+}
+
+// SetTextLayout sets how the text of words is added to pages. With
+// LineRunTextLayout or LinesTextLayout, text is sized to the height of each line and scaled
+// horizontally to the average width of its words, so the text scaling,
+// word rotation, box padding and style settings don't apply.
+func (d *Document) SetTextLayout(layout TextLayout) {
+	d.textLayout = layout
+}
+
 // SetOrientation sets the orientation of new pages
 func (d *Document) SetOrientation(orientation Orientation) {
 	d.orientation = orientation
 }
 
+// SetRotateWords enables the rotation of each word's text to match the angle
+// of its baseline, such that text on angled stamps or annotations can be
+// selected correctly.
+func (d *Document) SetRotateWords(enabled bool) {
+	d.rotateWords = enabled
+}
+
+// SetFitAspect enables the sizing of pages to fit images whose aspect ratio
+// (of the long edge to the short edge) exceeds the given ratio, such as long
+// receipts or panoramas (0 = disabled). Such pages keep the short edge of the
+// document page size, and are lengthened to match the image, rather than
+// shrinking the image to fit a standard page.
+func (d *Document) SetFitAspect(ratio float64) {
+	d.fitAspect = ratio
+}
+
+// pageMargins is the space, in page units, left around the image of a page.
+type pageMargins struct {
+	left, top, right, bottom float64
+}
+
+// SetPageMargins sets the space, in page units, left between the image and
+// each edge of subsequent pages. Images are fitted within the margins, and
+// the page is shrunk around them as usual, such that the margins are kept.
+// The text layer is offset to stay aligned with the image. (This is distinct
+// from the Fpdf SetMargins method, which sets the margins of cell text.)
+func (d *Document) SetPageMargins(left, top, right, bottom float64) {
+	d.margins = pageMargins{left, top, right, bottom}
+}
+
+// SetImageFit sets how the images of subsequent pages are placed on them
+// (PageImageFit by default). With FitImageFit, FillImageFit, CenterImageFit
+// and ActualImageFit, pages are always the document size. The actual size of
+// an image is given by the source DPI (see SetSourceDPI), or failing that,
+// the resolution recorded in the image; images of unknown resolution are
+// placed as per FitImageFit instead. Images are placed within any page
+// margins (see SetPageMargins), and the text layer follows the image.
+func (d *Document) SetImageFit(fit ImageFit) {
+	d.imageFit = fit
+}
+
+// imageBox returns the position and size of an image, of the given
+// dimensions as displayed, on a page of the given size, as per the image
+// fit. Boxes extend beyond the page (or its margins) where the image is cut
+// off.
+func (d *Document) imageBox(image Image, vw, vh, pw, ph float64) (
+	x, y, w, h float64) {
+	m := d.margins
+	x, y = m.left, m.top
+	bw, bh := pw-m.left-m.right, ph-m.top-m.bottom
+
+	// Actual sizes are in millimetres per pixel
+	res := float64(d.sourceDPI)
+	if res <= 0 {
+		xres, _ := image.GetResolution()
+		res = float64(xres)
+	}
+
+	fit := d.imageFit
+	if (fit == CenterImageFit || fit == ActualImageFit) && res <= 0 {
+		fit = FitImageFit
+	}
+	switch fit {
+	case FillImageFit:
+		s := math.Max(bw/vw, bh/vh)
+		w, h = vw*s, vh*s
+	case CenterImageFit, ActualImageFit:
+		w, h = vw*mmPerInch/res, vh*mmPerInch/res
+	default:
+		s := math.Min(bw/vw, bh/vh)
+		w, h = vw*s, vh*s
+	}
+	if fit != ActualImageFit {
+		x, y = x+(bw-w)/2, y+(bh-h)/2
+	}
+	return x, y, w, h
+}
+
+// Permissions granted to users of an encrypted document (see SetEncryption),
+// which may be combined, e.g. PermitPrint|PermitCopy.
+const (
+	// PermitPrint allows the document to be printed
+	PermitPrint = gofpdf.CnProtectPrint
+	// PermitModify allows the document to be modified by a PDF editor
+	PermitModify = gofpdf.CnProtectModify
+	// PermitCopy allows text and images to be copied to the clipboard
+	PermitCopy = gofpdf.CnProtectCopy
+	// PermitAnnotate allows annotations and form fields to be added
+	PermitAnnotate = gofpdf.CnProtectAnnotForms
+	// PermitAll grants all permissions
+	PermitAll = PermitPrint | PermitModify | PermitCopy | PermitAnnotate
+)
+
+// SetEncryption encrypts the document, requiring userPwd to open it, and
+// granting only the given permissions (see PermitAll) unless it is opened
+// with ownerPwd. An empty user password lets anyone open the document, and
+// an empty owner password is replaced with a random one, so the permissions
+// can't be lifted. Encryption uses the PDF standard security handler with
+// 40-bit RC4, which deters casual access but is not strong cryptography;
+// permissions are advisory, and not enforced by all PDF viewers. (This is
+// the Fpdf SetProtection method, with the arguments in a different order.)
+func (d *Document) SetEncryption(userPwd, ownerPwd string, perms int) {
+	d.SetProtection(byte(perms&PermitAll), userPwd, ownerPwd)
+	d.encrypted = true
+}
+
+// SetBoxPadding sets the fraction of each word's height by which its box is
+// expanded on each side before the word's text is placed in it (0 =
+// disabled). Word boxes fit the text of the image tightly, so padding makes
+// the text easier to select in PDF viewers. Words are not expanded into
+// their neighbours, and angled words (see SetRotateWords) are not expanded.
+func (d *Document) SetBoxPadding(padding float64) {
+	d.boxPadding = padding
+}
+
+// SetPageRotation sets the clockwise rotation, in degrees, with which the
+// images of subsequent pages are displayed. This corrects the orientation of
+// images (such as those with an EXIF orientation) without altering the image
+// data itself. Rotations are rounded to the nearest multiple of 90 degrees.
+// Words given to AddPage must be positioned relative to the rotated image,
+// such as by recognising text in a copy rotated with RotateOrth.
+func (d *Document) SetPageRotation(degrees int) {
+	quads := int(math.Floor(float64(degrees)/90 + 0.5))
+	d.pageRotation = ((quads%4 + 4) % 4) * 90
+}
+
+// SetDisplayMode sets the zoom and page layout used when the document is
+// opened. In addition to the values accepted by gofpdf, zoom may be "fit" to
+// fit the whole page in the window, or "width" to fit the page's width.
+// New documents are displayed a single page at a time, fitted to the window.
+func (d *Document) SetDisplayMode(zoom, layout string) {
+	switch zoom {
+	case "fit":
+		zoom = "fullpage"
+	case "width":
+		zoom = "fullwidth"
+	}
+	d.Fpdf.SetDisplayMode(zoom, layout)
+}
+
+// SetFont sets the font used for the text layer. See gofpdf.Fpdf.SetFont.
+func (d *Document) SetFont(family, style string, size float64) {
+	d.fontFamily = family
+	d.fontStyle = style
+	d.Fpdf.SetFont(family, style, size)
+}
+
+// SetUnicodeFont embeds the TrueType font in fontFile, under the given family
+// name, and uses it for the text layer (keeping the current style and size).
+// Unlike the core fonts, which only cover Latin-1, this allows the text of any
+// language the font supports to be searched. The same font is used for every
+// style. As the line-run text layout can't register the characters it uses
+// with the embedded font, words are always laid out in cells instead.
+func (d *Document) SetUnicodeFont(family, fontFile string) {
+	data, err := ioutil.ReadFile(fontFile)
+	if err != nil {
+		d.SetError(err)
+		return
+	}
+	for _, style := range []string{"", "B", "I", "BI"} {
+		d.AddUTF8FontFromBytes(family, style, data)
+	}
+	d.unicodeFont = true
+	d.fontFamily = family
+	fontSize, _ := d.GetFontSize()
+	d.Fpdf.SetFont(family, d.fontStyle, fontSize)
+}
+
+// translate returns the given UTF-8 text encoded for the current font.
+func (d *Document) translate(text string) string {
+	return d.translator()(text)
+}
+
+// translator returns a function that encodes UTF-8 text for the current
+// font, for encoding many strings at once.
+func (d *Document) translator() func(string) string {
+	if d.unicodeFont {
+		return func(text string) string { return text }
+	}
+	return d.UnicodeTranslatorFromDescriptor("")
+}
+
+// SetPreserveStyles enables the styling of each word's text as bold and/or
+// italic to match the word's appearance in the image, where detected. Serif
+// and monospace words are also given the Times and Courier core fonts, unless
+// an embedded font is used (see SetUnicodeFont).
+func (d *Document) SetPreserveStyles(enabled bool) {
+	d.preserveStyles = enabled
+}
+
+// SetAutoFontSize enables the sizing of each word's font to match the height
+// of the word, rather than using the current font size. Text then needs much
+// less scaling to fit each word, so is less distorted.
+func (d *Document) SetAutoFontSize(enabled bool) {
+	d.autoFontSize = enabled
+}
+
 // SetDebug enables debug mode, in which detected words are outlined, and the
 // text layer is arranged on top of the image (scan) layer.
 func (d *Document) SetDebug(enabled bool) {
 	d.debug = enabled
 }
 
+// SetImageFormat sets the format used to store images when none is given to
+// AddPage. See Image.Reader for supported formats.
+func (d *Document) SetImageFormat(format string) {
+	d.imageFormat = format
+}
+
+// SetJPEGQuality sets the quality (1-100) of images stored as JPEGs, and
+// whether they are progressive. A quality of 0 uses JPEGCompression.
+func (d *Document) SetJPEGQuality(quality int, progressive bool) {
+	d.encodeOptions = EncodeOptions{
+		JPEGQuality:     quality,
+		ProgressiveJPEG: progressive,
+	}
+}
+
+// SetContrast sets the amount of automatic contrast enhancement applied by
+// PrepareImage (0 = disabled).
+func (d *Document) SetContrast(amount float64) {
+	d.contrast = amount
+}
+
+// SetDPI sets the resolution that PrepareImage scales images down to, based
+// on the document's page size (0 = disabled).
+func (d *Document) SetDPI(dpi int) {
+	d.dpi = dpi
+}
+
+// SetSmoothScaling enables the smooth (antialiased) scaling of images to the
+// document DPI. Smoothing makes text in scaled images look much better, but
+// makes recognition less reliable, so when enabled, images are recognised at
+// their original resolution and only scaled when embedded in the page.
+func (d *Document) SetSmoothScaling(enabled bool) {
+	d.smoothScaling = enabled
+}
+
+// PrepareImage applies the document's image settings to the given image
+// before recognition, scaling it down to the document DPI (unless smooth
+// scaling is enabled) and enhancing its contrast as configured. The contrast
+// of bi-level images isn't enhanced (see AdjustContrast).
+func (d *Document) PrepareImage(image *Image) *Image {
+	original := image
+	if d.dpi > 0 && !d.smoothScaling {
+		// Resize image to requested d/in (rather, d/mm)
+		dpmm := float64(d.dpi) / mmPerInch
+		pw, ph := d.GetPageSize()
+		if iw, ih, _ := image.Dimensions(); (iw > ih) != (pw > ph) {
+			// Image will be placed on a page of the same orientation
+			pw, ph = ph, pw
+		}
+		image = image.ScaleDown(int32(pw*dpmm), int32(ph*dpmm))
+	}
+	if d.contrast > 0 {
+		adjusted := image.Adjust(float32(d.contrast))
+		if adjusted != image && image != original {
+			// The scaled copy is no longer needed
+			image.Close()
+		}
+		image = adjusted
+	}
+	return image
+}
+
+// SetImageHook registers a function to be called with the image data that is
+// embedded in each page, after all conversion has taken place. This is useful
+// for verifying exactly what was stored in the document.
+func (d *Document) SetImageHook(hook ImageHook) {
+	d.imageHook = hook
+}
+
 // AddImageLayer adds the specified image to the page, embedding it using
 // the given format, and appear at the specified size (in page units).
 func (d *Document) AddImageLayer(image Image, imagename string,
@@ -77,12 +493,44 @@ func (d *Document) AddImageLayer(image Image, imagename string,
 
 	pdf.BeginLayer(d.scanLayerID)
 
+	img := &image
+	if d.dpi > 0 && d.smoothScaling {
+		// Scale image to document DPI for embedding only
+		dpmm := float64(d.dpi) / mmPerInch
+		img = img.ScaleDownSmooth(int32(w*dpmm), int32(h*dpmm))
+	}
+	if img == &image && w > 0 && h > 0 {
+		// The resolution is recorded on a copy, as the caller's image must
+		// not be modified
+		if clone := img.Clone(); clone != nil {
+			img = clone
+		}
+	}
+	if img != &image {
+		defer img.Close()
+	} else {
+		defer runtime.KeepAlive(img)
+	}
+
+	// Record effective resolution, so extracted images have the correct
+	// physical size
+	if img != &image && w > 0 && h > 0 {
+		iw, ih, _ := img.Dimensions()
+		img.SetResolution(int32(math.Floor(float64(iw)*mmPerInch/w+0.5)),
+			int32(math.Floor(float64(ih)*mmPerInch/h+0.5)))
+	}
+
 	// Register image
-	reader, imageFormat, err := image.Reader(format)
+	reader, imageFormat, err := img.ReaderWithOptions(format, d.encodeOptions)
 	if err != nil {
 		pdf.SetError(err)
 		return
 	}
+	if d.imageHook != nil {
+		data := make([]byte, reader.Len())
+		copy(data, reader.Bytes())
+		d.imageHook(pdf.PageNo(), data, imageFormat)
+	}
 	pdf.RegisterImageReader(imagename, imageFormat, reader)
 
 	if d.debug {
@@ -97,13 +545,94 @@ func (d *Document) AddImageLayer(image Image, imagename string,
 	pdf.EndLayer()
 }
 
-// AddWords adds the specified words to the page.
+// AddWords adds the specified words to the page. Unless in debug mode, the
+// words are drawn with the invisible text rendering mode, so they can be
+// searched and selected without ever being painted. In debug mode, words are
+// coloured by confidence (see confidenceColor), so the areas that were hard
+// to recognise stand out.
 func (d *Document) AddWords(words []Word) {
 	pdf := d.Fpdf
-	for _, word := range words {
+
+	if !d.debug {
+		pdf.SetTextRenderingMode(invisibleTextMode)
+		defer pdf.SetTextRenderingMode(fillTextMode)
+	} else {
+		defer pdf.SetTextColor(0, 0, 0)
+	}
+
+	// The text of words is encoded for the font (cp1252 for the core
+	// fonts) before it's measured or written
+	translate := d.translator()
+	translated := make([]Word, len(words))
+	for i, word := range words {
+		word.Text = translate(word.Text)
+		translated[i] = word
+	}
+	words = translated
+
+	if d.textLayout == LineRunTextLayout && !d.unicodeFont {
+		for _, line := range lineWords(words) {
+			d.addLineRun(line)
+		}
+		return
+	}
+	if d.textLayout == LinesTextLayout && !d.unicodeFont {
+		d.addLines(lineWords(words))
+		return
+	}
+
+	ps := d.pixelSize()
+
+	if d.preserveStyles {
+		// Restore the document font after matching each word's
+		fontSize, _ := pdf.GetFontSize()
+		defer pdf.SetFont(d.fontFamily, d.fontStyle, fontSize)
+	}
+
+	var padded []Word
+	if d.boxPadding > 0 {
+		padded = padWords(words, d.boxPadding)
+	}
+
+	for i, word := range words {
 		x, y := float64(word.Left), float64(word.Top)
 		w, h := float64(word.Width), float64(word.Height)
 
+		if d.debug {
+			// Outline detected word area
+			pdf.SetDrawColor(confidenceColor(word.Confidence))
+			pdf.SetTextColor(confidenceColor(word.Confidence))
+			pdf.Rect(x*ps, y*ps, w*ps, h*ps, "D")
+		}
+
+		if padded != nil {
+			// Enlarge text to make it easier to select
+			x, y = float64(padded[i].Left), float64(padded[i].Top)
+			w, h = float64(padded[i].Width), float64(padded[i].Height)
+		}
+
+		// Rotate text to follow baseline of angled words
+		angle := 0.0
+		if d.rotateWords {
+			angle = word.Angle()
+		}
+		rotate := math.Abs(angle) >= minWordAngle
+		if rotate {
+			x, y, w, h = rotatedWordBox(word, angle)
+		}
+		x, y, w, h = x*ps, y*ps, w*ps, h*ps
+
+		if d.preserveStyles {
+			// Match appearance of word
+			fontSize, _ := pdf.GetFontSize()
+			pdf.SetFont(d.wordFamily(word), d.wordStyle(word), fontSize)
+		}
+
+		if d.autoFontSize && h > 0 {
+			// Size font to word height, so it needs minimal scaling
+			pdf.SetFontUnitSize(h)
+		}
+
 		// Scaling factors
 		sx, sy := 1.0, 1.0
 
@@ -133,20 +662,17 @@ func (d *Document) AddWords(words []Word) {
 			sy = h / sh
 		}
 
-		if d.debug {
-			// Outline detected word area
-			pdf.SetDrawColor(255, 0, 0)
-			pdf.Rect(x, y, w, h, "D")
-		}
-
 		// Print word in area of original box
 		pdf.SetXY(x, y)
 		pdf.TransformBegin()
+		if rotate {
+			pdf.TransformRotate(angle, x, y+h)
+		}
 		pdf.TransformScale(100*sx, 100*sy, x, y)
 		if d.debug {
-			// Highlight target area in green
+			// Highlight target area in the word's colour
 			pdf.SetAlpha(0.5, "Multiply")
-			pdf.SetFillColor(0, 255, 0)
+			pdf.SetFillColor(confidenceColor(word.Confidence))
 			pdf.Rect(x, y, sw, sh, "F")
 			pdf.SetAlpha(1.0, "Normal")
 		}
@@ -156,12 +682,216 @@ func (d *Document) AddWords(words []Word) {
 	}
 }
 
+// confidenceColor returns the colour of text of the given confidence (0-100)
+// in debug mode, which ranges from red for no confidence, through amber, to
+// green for full confidence.
+func confidenceColor(confidence float32) (r, g, b int) {
+	c := math.Max(0, math.Min(100, float64(confidence)))
+	if c < 50 {
+		return 220, int(160 * c / 50), 0
+	}
+	return int(220 * (100 - c) / 50), 160, 0
+}
+
+// wordStyle returns the font style for the given word, which is the style of
+// the document font, made bold and/or italic to match the word.
+func (d *Document) wordStyle(word Word) string {
+	style := strings.Map(func(r rune) rune {
+		if r == 'B' || r == 'I' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(d.fontStyle))
+	if word.Bold {
+		style += "B"
+	}
+	if word.Italic {
+		style += "I"
+	}
+	return style
+}
+
+// wordFamily returns the font family for the given word, which is the
+// document font, unless the word is serif or monospace and the document font
+// isn't embedded, in which case it's the matching core font.
+func (d *Document) wordFamily(word Word) string {
+	switch {
+	case d.unicodeFont:
+		return d.fontFamily
+	case word.Monospace:
+		return "Courier"
+	case word.Serif:
+		return "Times"
+	}
+	return d.fontFamily
+}
+
+// rotatedWordBox returns the unrotated box that, when rotated by angle
+// degrees about its bottom-left corner, covers the given word. The box is
+// positioned such that its bottom edge lies along the word's baseline.
+func rotatedWordBox(word Word, angle float64) (x, y, w, h float64) {
+	rad := angle * math.Pi / 180
+	cos, sin := math.Abs(math.Cos(rad)), math.Abs(math.Sin(rad))
+
+	// The bounding box of a rotated box of length l and height t has width
+	// (l*cos + t*sin) and height (l*sin + t*cos), so solve for t using
+	// whichever is the more stable.
+	w = math.Hypot(float64(word.BaselineX2-word.BaselineX1),
+		float64(word.BaselineY2-word.BaselineY1))
+	if cos >= sin {
+		h = (float64(word.Height) - w*sin) / cos
+	} else {
+		h = (float64(word.Width) - w*cos) / sin
+	}
+	if h <= 0 {
+		h = math.Min(float64(word.Width), float64(word.Height))
+	}
+
+	x, y = float64(word.BaselineX1), float64(word.BaselineY1)-h
+	return x, y, w, h
+}
+
+// AddBookmark adds an entry to the document outline that links to the top of
+// the current page. Level 0 entries are at the top of the outline, and each
+// entry of level n is nested under the preceding entry of level n-1.
+func (d *Document) AddBookmark(title string, level int) {
+	d.Bookmark(d.translate(title), level, 0)
+}
+
+// ContentsEntry is an entry in a table of contents, linking to a page.
+type ContentsEntry struct {
+	Title string
+	Page  int
+}
+
+// AddContentsPage appends a page (or several, if needed) with the given
+// title, listing the given entries and the numbers of their pages, each
+// linking to its page. Pages must already exist in order to be linked to, so
+// the contents are always added after the pages they list.
+func (d *Document) AddContentsPage(title string, entries []ContentsEntry) {
+	const margin, lineHeight, pageNoWidth = 20.0, 7.0, 15.0
+
+	pdf := d.Fpdf
+
+	// Restore the font used for words afterwards
+	fontSize, _ := pdf.GetFontSize()
+	defer pdf.SetFontSize(fontSize)
+	defer pdf.SetFontStyle(d.fontStyle)
+
+	pdf.AddPage()
+	w, h := pdf.GetPageSize()
+	pdf.SetXY(margin, margin)
+	pdf.SetFontStyle("B")
+	pdf.SetFontSize(16)
+	pdf.CellFormat(w-2*margin, 2*lineHeight, d.translate(title), "", 1, "L", false, 0,
+		"")
+
+	pdf.SetFontStyle("")
+	pdf.SetFontSize(11)
+	for _, entry := range entries {
+		if pdf.GetY()+lineHeight > h-margin {
+			pdf.AddPage()
+			pdf.SetY(margin)
+		}
+
+		link := pdf.AddLink()
+		pdf.SetLink(link, 0, entry.Page)
+		pdf.SetX(margin)
+		pdf.CellFormat(w-2*margin-pageNoWidth, lineHeight, d.translate(entry.Title),
+			"", 0, "L", false, link, "")
+		pdf.CellFormat(pageNoWidth, lineHeight, strconv.Itoa(entry.Page),
+			"", 1, "R", false, link, "")
+	}
+}
+
+// Write writes the complete document to w, without closing it, so the
+// document can be written to a buffer or network connection that is still
+// needed afterwards. Any error encountered whilst building the document is
+// returned.
+func (d *Document) Write(w io.Writer) error {
+	return d.Output(w)
+}
+
+// ClearMetadata removes all entries from the document's information
+// dictionary, including the producer. As creation and modification dates are
+// always written, both are set to the Unix epoch so that they don't reveal
+// when the document was made.
+func (d *Document) ClearMetadata() {
+	d.SetTitle("", false)
+	d.SetSubject("", false)
+	d.SetKeywords("", false)
+	d.SetAuthor("", false)
+	d.SetCreator("", false)
+	d.SetProducer("", false)
+	epoch := time.Unix(0, 0).UTC()
+	d.SetCreationDate(epoch)
+	d.SetModificationDate(epoch)
+}
+
+// SetPDFA requests a document conforming to the given PDF/A level, such as
+// "1b" (an empty level disables PDF/A). Conformance requires an ICC output
+// intent in the document catalog, which gofpdf can't write, and forbids the
+// optional content (layers) that text and images are drawn in, as well as
+// non-embedded fonts and (in debug mode) transparency. As a conformant
+// document can't be produced, rather than silently writing one that isn't,
+// the document's error is set, listing the reasons why.
+func (d *Document) SetPDFA(level string) {
+	switch level {
+	case "":
+		return
+	case "1b":
+	default:
+		d.SetErrorf("unsupported PDF/A level '%s'", level)
+		return
+	}
+
+	reasons := []string{
+		"output intents aren't supported by the PDF library",
+		"text and images are drawn in optional content layers",
+	}
+	if !d.unicodeFont {
+		reasons = append(reasons, "the text layer font isn't embedded "+
+			"(use a font file)")
+	}
+	if d.debug {
+		reasons = append(reasons, "debug mode uses transparency")
+	}
+	if d.encrypted {
+		reasons = append(reasons, "encryption is forbidden")
+	}
+	d.SetErrorf("can't produce PDF/A-%s: %s", level,
+		strings.Join(reasons, "; "))
+}
+
 // GetPageConfiguration returns a suitable page size and orientation to
-// contain an image of the specified dimensions.
+// contain an image of the specified dimensions within the page margins.
 func (d *Document) GetPageConfiguration(iw, ih float64) (
 	w, h float64, orientation Orientation) {
 
 	w, h = d.GetPageSize()
+	m := d.margins
+	mw, mh := m.left+m.right, m.top+m.bottom
+
+	if d.fitAspect > 0 && math.Max(iw, ih) > d.fitAspect*math.Min(iw, ih) {
+		// Lengthen page to fit image
+		short := math.Min(w, h)
+		if iw > ih {
+			h = short - mh
+			w = h * iw / ih
+			if w+mw > maxPageLength {
+				w, h = maxPageLength-mw, (maxPageLength-mw)*ih/iw
+			}
+			orientation = LandscapeOrientation
+		} else {
+			w = short - mw
+			h = w * ih / iw
+			if h+mh > maxPageLength {
+				w, h = (maxPageLength-mh)*iw/ih, maxPageLength-mh
+			}
+			orientation = PortraitOrientation
+		}
+		return w + mw, h + mh, orientation
+	}
 
 	// Add page with correct orientation
 	orientation = d.orientation
@@ -174,46 +904,112 @@ func (d *Document) GetPageConfiguration(iw, ih float64) (
 		}
 	}
 
+	if d.imageFit != "" && d.imageFit != PageImageFit {
+		// Image is fitted to the page instead (see imageBox)
+		return w, h, orientation
+	}
+
+	w, h = w-mw, h-mh
 	if iw*h < ih*w {
 		w = h * iw / ih
 	} else {
 		h = w * ih / iw
 	}
 
-	return w, h, orientation
+	return w + mw, h + mh, orientation
 }
 
 // AddPage appends the given image to the document, annotating the document
 // with the detected words. Ensure `name` is unique for each distinct image.
+// If format is empty, the document's image format is used.
 func (d *Document) AddPage(image Image, imagename string,
 	words []Word, format string) error {
 	iw, ih, _ := image.Dimensions()
-	w, h, orientation := d.GetPageConfiguration(float64(iw), float64(ih))
 
-	d.AddPageFormat(string(orientation), gofpdf.SizeType{Wd: w, Ht: h})
+	// Size page to image as displayed
+	vw, vh := float64(iw), float64(ih)
+	if d.pageRotation%180 != 0 {
+		vw, vh = vh, vw
+	}
+	pw, ph, orientation := d.GetPageConfiguration(vw, vh)
+
+	if format == "" {
+		format = d.imageFormat
+	}
+
+	m := d.margins
+	if pw <= m.left+m.right || ph <= m.top+m.bottom {
+		d.SetErrorf("page margins leave no space for the image")
+		return d.Error()
+	}
+
+	d.AddPageFormat(string(orientation), gofpdf.SizeType{Wd: pw, Ht: ph})
+
+	page := d.PageNo()
+
+	// The image and its words are placed within the margins, and cut off
+	// at them if the image is larger
+	x, y, w, h := d.imageBox(image, vw, vh, pw, ph)
+	bw, bh := pw-m.left-m.right, ph-m.top-m.bottom
+	clip := x < m.left-0.01 || y < m.top-0.01 ||
+		x+w > m.left+bw+0.01 || y+h > m.top+bh+0.01
 
 	addImageLayer := func() {
-		d.AddImageLayer(image, imagename, format, w, h)
+		d.pageLayers[page] = append(d.pageLayers[page], d.scanLayerID)
+		if d.pageRotation == 0 {
+			d.AddImageLayer(image, imagename, format, w, h)
+			return
+		}
+
+		// Rotate about the point that keeps the image on the page
+		d.TransformBegin()
+		switch d.pageRotation {
+		case 90:
+			d.TransformRotate(-90, w/2, w/2)
+			d.AddImageLayer(image, imagename, format, h, w)
+		case 180:
+			d.TransformRotate(-180, w/2, h/2)
+			d.AddImageLayer(image, imagename, format, w, h)
+		case 270:
+			d.TransformRotate(-270, h/2, h/2)
+			d.AddImageLayer(image, imagename, format, h, w)
+		}
+		d.TransformEnd()
 	}
 
+	// Leave junk words out of the text layer
+	filtered, _ := FilterWords(words, d.minConfidence)
+
 	addWordsLayer := func() {
-		mx, my := w/float64(iw), h/float64(ih)
+		d.pageLayers[page] = append(d.pageLayers[page], d.ocrLayerID)
+		// Words are already in page units if the source DPI is known, so
+		// only need scaling for any difference in page size
+		ps := d.pixelSize()
+		mx, my := w/vw/ps, h/vh/ps
 		d.BeginLayer(d.ocrLayerID)
 		d.TransformBegin()
 		d.TransformScale(100*mx, 100*my, 0, 0)
-		d.AddWords(words)
+		d.AddWords(filtered)
 		d.TransformEnd()
 		d.EndLayer()
 	}
 
-	if d.debug {
-		// Draw text on top of image
-		addImageLayer()
-		addWordsLayer()
-	} else {
-		// Hide text below image
-		addWordsLayer()
-		addImageLayer()
+	// Text is invisible (or visible on a semi-transparent image in debug
+	// mode), so is drawn on top of the image
+	if clip {
+		d.ClipRect(m.left, m.top, bw, bh, false)
+	}
+	if x != 0 || y != 0 {
+		d.TransformBegin()
+		d.TransformTranslate(x, y)
+	}
+	addImageLayer()
+	addWordsLayer()
+	if x != 0 || y != 0 {
+		d.TransformEnd()
+	}
+	if clip {
+		d.ClipEnd()
 	}
 
 	if err := d.Error(); err != nil {
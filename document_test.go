@@ -0,0 +1,240 @@
+package ocrpdf
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// wordsPDF draws the given words on a page of a new uncompressed document,
+// with or without word rotation, and returns the document.
+func wordsPDF(t *testing.T, rotate bool, words []Word) string {
+	t.Helper()
+	d := NewDocument("a4")
+	d.SetCompression(false)
+	d.SetRotateWords(rotate)
+	d.AddPageFormat("P", gofpdf.SizeType{Wd: 210, Ht: 297})
+	d.SetFont("Arial", "", 10)
+	d.AddWords(words)
+
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+// rotationMatrix returns the start of the transformation that gofpdf writes
+// for a rotation of the given number of degrees.
+func rotationMatrix(degrees float64) string {
+	rad := degrees * math.Pi / 180
+	return fmt.Sprintf("%.5f %.5f %.5f %.5f ", math.Cos(rad), math.Sin(rad),
+		-math.Sin(rad), math.Cos(rad))
+}
+
+func TestRotateWords(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		x1, y1, x2, y2 int
+		angle          float64
+	}{
+		{"rising", 10, 60, 60, 10, 45},
+		{"falling", 10, 10, 60, 60, -45},
+		{"upwards", 30, 90, 30, 10, 90},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			word := Word{
+				Text: "Stamped",
+				Left: 10, Top: 10, Right: 60, Bottom: 90,
+				Width: 50, Height: 80,
+				BaselineX1: test.x1, BaselineY1: test.y1,
+				BaselineX2: test.x2, BaselineY2: test.y2,
+			}
+			if angle := word.Angle(); math.Abs(angle-test.angle) > 1e-9 {
+				t.Fatalf("baseline has angle %g, expected %g", angle,
+					test.angle)
+			}
+
+			rotation := rotationMatrix(test.angle)
+			if !strings.Contains(wordsPDF(t, true, []Word{word}), rotation) {
+				t.Errorf("text isn't rotated by %g degrees to follow its "+
+					"baseline", test.angle)
+			}
+			if strings.Contains(wordsPDF(t, false, []Word{word}), rotation) {
+				t.Error("text is rotated with word rotation disabled")
+			}
+		})
+	}
+}
+
+// testPageImage returns a small page image with a dark block on it, read from
+// a PGM file, which Leptonica reads without any other image libraries.
+func testPageImage(t *testing.T) *Image {
+	t.Helper()
+	w, h := 120, 90
+	data := []byte(fmt.Sprintf("P5\n%d %d\n255\n", w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if x > 20 && x < 80 && y > 30 && y < 50 {
+				data = append(data, 0)
+			} else {
+				data = append(data, 255)
+			}
+		}
+	}
+
+	fn := filepath.Join(t.TempDir(), "page.pgm")
+	if err := ioutil.WriteFile(fn, data, 0666); err != nil {
+		t.Fatal(err)
+	}
+	img, err := NewImageFromFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// layeredPage returns a new uncompressed document containing a page of the
+// test image with a word on it, and the content stream of that page.
+func layeredPage(t *testing.T, debug bool) (*Document, string) {
+	t.Helper()
+	d := NewDocument("a4")
+	d.SetCompression(false)
+	d.SetDebug(debug)
+	d.SetFont("Arial", "", 10)
+
+	img := testPageImage(t)
+	words := []Word{{Text: "Hello", Left: 20, Top: 30, Right: 80,
+		Bottom: 50, Width: 60, Height: 20}}
+	err := d.AddPage(*img, "page", words, "png")
+	runtime.KeepAlive(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The content of the only page is the first stream
+	pdf := buf.String()
+	if end := strings.Index(pdf, "endstream"); end >= 0 {
+		pdf = pdf[:end]
+	}
+	return d, pdf
+}
+
+// checkDrawOrder checks that each of the given strings appears in content,
+// in the given order.
+func checkDrawOrder(t *testing.T, content string, order ...string) {
+	t.Helper()
+	last := -1
+	for n, s := range order {
+		i := strings.Index(content, s)
+		if i < 0 {
+			t.Errorf("%q not drawn", s)
+			return
+		}
+		if i < last {
+			t.Errorf("%q drawn before %q", s, order[n-1])
+		}
+		last = i
+	}
+}
+
+func TestLayerOrder(t *testing.T) {
+	// Text is drawn with the invisible rendering mode rather than being
+	// hidden beneath the image, so is drawn on top of the image in both
+	// modes, and only painted when debugging
+	for _, debug := range []bool{false, true} {
+		d, content := layeredPage(t, debug)
+		if order := d.LayerOrder(1); len(order) != 2 ||
+			order[0] != d.ScanLayerID() || order[1] != d.OCRLayerID() {
+			t.Errorf("layers drawn in order %v (debug %t), expected image "+
+				"then text", order, debug)
+		}
+
+		ocr := fmt.Sprintf("/OC /OC%d BDC", d.OCRLayerID())
+		scan := fmt.Sprintf("/OC /OC%d BDC", d.ScanLayerID())
+		checkDrawOrder(t, content, scan, " Do Q", ocr, "BT ")
+
+		text := content
+		if i := strings.Index(content, ocr); i >= 0 {
+			text = content[i:]
+		}
+		invisible := strings.Contains(text,
+			fmt.Sprintf("%d Tr", invisibleTextMode))
+		if invisible == debug {
+			t.Errorf("text invisible is %t in debug mode %t", invisible,
+				debug)
+		}
+	}
+}
+
+func TestLinesTextLayout(t *testing.T) {
+	words := []Word{
+		{Text: "Dear", Left: 10, Top: 10, Right: 50, Bottom: 30, Width: 40,
+			Height: 20, Confidence: 90},
+		{Text: "Sir,", Left: 60, Top: 10, Right: 90, Bottom: 30, Width: 30,
+			Height: 20, Confidence: 90},
+		{Text: "Regards", Left: 10, Top: 50, Right: 80, Bottom: 70,
+			Width: 70, Height: 20, Confidence: 90},
+	}
+	d := NewDocument("a4")
+	d.SetCompression(false)
+	d.SetTextLayout(LinesTextLayout)
+	d.AddPageFormat("P", gofpdf.SizeType{Wd: 210, Ht: 297})
+	d.SetFont("Arial", "", 10)
+	d.AddWords(words)
+
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	content := buf.String()
+
+	// Both lines are in a single text object, each started by a text matrix
+	start := strings.Index(content, "q BT ")
+	if start < 0 {
+		t.Fatal("no text object found")
+	}
+	end := strings.Index(content[start:], " ET Q")
+	if end < 0 {
+		t.Fatal("text object isn't ended")
+	}
+	text := content[start : start+end]
+	if n := strings.Count(text, " Tm ["); n != 2 {
+		t.Errorf("text object starts %d lines, expected 2", n)
+	}
+	first := strings.Index(text, "(Dear ")
+	second := strings.Index(text, "(Regards)")
+	if first < 0 || second < 0 || second < first {
+		t.Errorf("lines aren't in reading order: %s", text)
+	}
+}
+
+func TestLineRunEncoding(t *testing.T) {
+	d := NewDocument("a4")
+	d.SetCompression(false)
+	d.SetTextLayout(LineRunTextLayout)
+	d.AddPageFormat("P", gofpdf.SizeType{Wd: 210, Ht: 297})
+	d.SetFont("Arial", "", 10)
+	d.AddWords([]Word{{Text: "café", Left: 10, Top: 10, Right: 50,
+		Bottom: 30, Width: 40, Height: 20, Confidence: 90}})
+
+	var buf bytes.Buffer
+	if err := d.Output(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// The core fonts are cp1252 encoded, in which é is a single byte
+	if !strings.Contains(buf.String(), "[(caf\xe9)] TJ") {
+		t.Error("line run text isn't encoded for the font")
+	}
+}
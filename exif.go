@@ -0,0 +1,135 @@
+package ocrpdf
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// exifOrientationTag is the EXIF tag that records image orientation.
+const exifOrientationTag = 0x0112
+
+// exifRotations maps EXIF orientations to the clockwise rotation, in degrees,
+// required to display the image upright. Mirrored orientations (2, 4, 5 and
+// 7) are not supported (see ErrMirroredOrientation).
+var exifRotations = map[uint16]int{
+	3: 180,
+	6: 90,
+	8: 270,
+}
+
+// ErrMirroredOrientation is returned by EXIFRotation for images whose EXIF
+// orientation is mirrored (2, 4, 5 or 7), which can't be corrected by
+// rotation alone, so are left as-is.
+var ErrMirroredOrientation = errors.New("mirrored EXIF orientation isn't " +
+	"supported")
+
+// EXIFRotation returns the clockwise rotation, in degrees, required to
+// display the image in the named file upright, according to its EXIF
+// orientation. Returns 0 if the file is not a JPEG, or records no
+// orientation, and 0 with ErrMirroredOrientation if the orientation is
+// mirrored.
+func EXIFRotation(filename string) (int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return readEXIFRotation(bufio.NewReader(f))
+}
+
+// readEXIFRotation finds the EXIF segment of a JPEG and reads the image
+// orientation from it.
+func readEXIFRotation(r io.Reader) (int, error) {
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi != [2]byte{0xff, 0xd8} {
+		// Not a JPEG
+		return 0, nil
+	}
+
+	var b [2]byte
+	for {
+		if _, err := io.ReadFull(r, b[:1]); err != nil {
+			return 0, err
+		}
+		if b[0] != 0xff {
+			// Not a marker, so the file is corrupt
+			return 0, nil
+		}
+
+		// Markers may be preceded by any number of 0xff fill bytes
+		marker := byte(0xff)
+		for marker == 0xff {
+			if _, err := io.ReadFull(r, b[:1]); err != nil {
+				return 0, err
+			}
+			marker = b[0]
+		}
+		switch {
+		case marker == 0xda || marker == 0xd9 || marker == 0x00:
+			// Reached image data without finding EXIF segment
+			return 0, nil
+		case marker == 0x01 || marker >= 0xd0 && marker <= 0xd8:
+			// Standalone markers (TEM, RSTn and SOI) have no segment
+			continue
+		}
+
+		if _, err := io.ReadFull(r, b[:]); err != nil {
+			return 0, err
+		}
+		length := int(binary.BigEndian.Uint16(b[:])) - 2
+		if length < 0 {
+			return 0, nil
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return 0, err
+		}
+
+		if marker == 0xe1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			switch orientation := exifOrientation(segment[6:]); orientation {
+			case 2, 4, 5, 7:
+				return 0, ErrMirroredOrientation
+			default:
+				return exifRotations[orientation], nil
+			}
+		}
+	}
+}
+
+// exifOrientation returns the orientation recorded in the first IFD of the
+// given TIFF-structured EXIF data, or 0 if none is recorded.
+func exifOrientation(data []byte) uint16 {
+	if len(data) < 8 {
+		return 0
+	}
+
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	offset := int(order.Uint32(data[4:]))
+	if offset < 8 || offset+2 > len(data) {
+		return 0
+	}
+	n := int(order.Uint16(data[offset:]))
+	for i := 0; i < n; i++ {
+		entry := offset + 2 + 12*i
+		if entry+12 > len(data) {
+			break
+		}
+		if order.Uint16(data[entry:]) == exifOrientationTag {
+			return order.Uint16(data[entry+8:])
+		}
+	}
+	return 0
+}
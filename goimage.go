@@ -0,0 +1,169 @@
+package ocrpdf
+
+// #include "leptonica/allheaders.h"
+import "C"
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"unsafe"
+)
+
+// jpegEOI is the marker that terminates JPEG data.
+var jpegEOI = []byte{0xff, 0xd9}
+
+// recoverJPEG decodes a corrupt or truncated JPEG file using Go's decoder,
+// which tolerates some errors that libjpeg (and so pixRead) does not.
+// Truncated data is terminated before decoding, such that as much of the
+// image as possible is recovered.
+func recoverJPEG(filename string) (*C.PIX, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil && !bytes.HasSuffix(data, jpegEOI) {
+		img, err = jpeg.Decode(io.MultiReader(
+			bytes.NewReader(data), bytes.NewReader(jpegEOI)))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newPIXFromGoImage(img), nil
+}
+
+// NewImageFromGo creates an image from a copy of a Go image, such as one
+// decoded in memory or captured from a camera. Greyscale images (including
+// 16-bit greyscale, and paletted images of only greys) are stored as 8bpp
+// greyscale, and all others as 32bpp RGB. Transparent areas are composited
+// onto white, as they would appear on paper.
+func NewImageFromGo(img image.Image) (*Image, error) {
+	if img.Bounds().Empty() {
+		return nil, fmt.Errorf("could not create image from empty image")
+	}
+	cPIX := newPIXFromGoImage(img)
+	if cPIX == nil {
+		return nil, fmt.Errorf("could not create image")
+	}
+	return newImage(cPIX, C.IFF_UNKNOWN), nil
+}
+
+// ToGo returns a copy of the image as a Go image, such as for previews.
+// Greyscale images are returned as *image.Gray, and all others (including
+// bi-level and colormapped images) as *image.RGBA.
+func (i *Image) ToGo() (image.Image, error) {
+	defer runtime.KeepAlive(i)
+	w, h, depth := i.Dimensions()
+	rect := image.Rect(0, 0, int(w), int(h))
+
+	cPIX := i.cPIX
+	if depth == 8 && C.pixGetColormap(cPIX) == nil {
+		gray := image.NewGray(rect)
+		wpl := int(C.pixGetWpl(cPIX))
+		data := unsafe.Slice(C.pixGetData(cPIX), wpl*int(h))
+		for y := 0; y < int(h); y++ {
+			line := data[y*wpl : (y+1)*wpl]
+			row := gray.Pix[y*gray.Stride:]
+			for x := 0; x < int(w); x++ {
+				row[x] = uint8(line[x/4] >> uint(24-8*(x%4)))
+			}
+		}
+		return gray, nil
+	}
+
+	if depth != 32 || C.pixGetColormap(cPIX) != nil {
+		cPIX = C.pixConvertTo32(i.cPIX)
+		if cPIX == nil {
+			return nil, fmt.Errorf("could not convert %dbpp image", depth)
+		}
+		defer C.pixDestroy(&cPIX)
+	}
+
+	rgba := image.NewRGBA(rect)
+	wpl := int(C.pixGetWpl(cPIX))
+	data := unsafe.Slice(C.pixGetData(cPIX), wpl*int(h))
+	for y := 0; y < int(h); y++ {
+		line := data[y*wpl : (y+1)*wpl]
+		row := rgba.Pix[y*rgba.Stride:]
+		for x := 0; x < int(w); x++ {
+			px := line[x]
+			row[4*x] = uint8(px >> 24)
+			row[4*x+1] = uint8(px >> 16)
+			row[4*x+2] = uint8(px >> 8)
+			row[4*x+3] = 0xff
+		}
+	}
+	return rgba, nil
+}
+
+// isGrayImage returns true if the given image can only contain greys.
+func isGrayImage(img image.Image) bool {
+	switch model := img.ColorModel().(type) {
+	case color.Palette:
+		for _, c := range model {
+			r, g, b, _ := c.RGBA()
+			if r != g || g != b {
+				return false
+			}
+		}
+		return true
+	default:
+		return model == color.GrayModel || model == color.Gray16Model
+	}
+}
+
+// newPIXFromGoImage creates a new PIX containing a copy of the given image,
+// composited onto white. Greyscale images (see isGrayImage) produce an 8bpp
+// PIX, and all others a 32bpp (RGB) PIX.
+func newPIXFromGoImage(img image.Image) *C.PIX {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	gray, isGray8 := img.(*image.Gray)
+	isGray := isGrayImage(img)
+	depth := 32
+	if isGray {
+		depth = 8
+	}
+
+	cPIX := C.pixCreate(C.l_int32(w), C.l_int32(h), C.l_int32(depth))
+	if cPIX == nil {
+		return nil
+	}
+
+	// Leptonica packs pixels into 32-bit words, with the leftmost pixel in
+	// the most significant bits
+	wpl := int(C.pixGetWpl(cPIX))
+	data := unsafe.Slice(C.pixGetData(cPIX), wpl*h)
+	for y := 0; y < h; y++ {
+		line := data[y*wpl : (y+1)*wpl]
+		for x := 0; x < w; x++ {
+			px, py := bounds.Min.X+x, bounds.Min.Y+y
+			if isGray8 {
+				v := C.l_uint32(gray.GrayAt(px, py).Y)
+				line[x/4] |= v << uint(24-8*(x%4))
+				continue
+			}
+
+			// Colours are alpha-premultiplied, so adding the transparency
+			// composites them onto white
+			r, g, b, a := img.At(px, py).RGBA()
+			r, g, b = r+0xffff-a, g+0xffff-a, b+0xffff-a
+			if isGray {
+				line[x/4] |= C.l_uint32(r>>8) << uint(24-8*(x%4))
+				continue
+			}
+			line[x] = C.l_uint32(r>>8)<<24 | C.l_uint32(g>>8)<<16 |
+				C.l_uint32(b>>8)<<8
+		}
+	}
+
+	return cPIX
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// altoHeader and altoFooter enclose the ALTO of each page in an ALTO
+// document, as per Tesseract's own ALTO renderer.
+const (
+	altoHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<alto xmlns="http://www.loc.gov/standards/alto/ns-v3#" xmlns:xlink="http://www.w3.org/1999/xlink" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:schemaLocation="http://www.loc.gov/standards/alto/ns-v3# http://www.loc.gov/alto/v3/alto-3-0.xsd">
+	<Description>
+		<MeasurementUnit>pixel</MeasurementUnit>
+		<OCRProcessing ID="OCR_0">
+			<ocrProcessingStep>
+				<processingSoftware>
+					<softwareName>tesseract</softwareName>
+				</processingSoftware>
+			</ocrProcessingStep>
+		</OCRProcessing>
+	</Description>
+	<Layout>
+`
+	altoFooter = `	</Layout>
+</alto>
+`
+)
+
+// altoPosition matches the position and size attributes of ALTO elements.
+var altoPosition = regexp.MustCompile(
+	`\b(HPOS|VPOS|WIDTH|HEIGHT)="([0-9.]+)"`)
+
+// scaleALTO returns the given ALTO with all positions and sizes multiplied
+// by factor, for an image scaled by the same amount.
+func scaleALTO(alto string, factor float64) string {
+	if factor == 1 {
+		return alto
+	}
+	return altoPosition.ReplaceAllStringFunc(alto, func(attr string) string {
+		m := altoPosition.FindStringSubmatch(attr)
+		v, err := strconv.ParseFloat(m[2], 64)
+		if err != nil {
+			return attr
+		}
+		return m[1] + `="` + strconv.Itoa(int(v*factor+0.5)) + `"`
+	})
+}
+
+// altoWriter writes the ALTO of each page to a single ALTO document.
+type altoWriter struct {
+	f *os.File
+}
+
+// newALTOWriter creates the named file and writes the document header to it.
+func newALTOWriter(fn string) (*altoWriter, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(f, altoHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &altoWriter{f: f}, nil
+}
+
+// WritePage writes the ALTO of a page, as returned by Tess.ALTOText.
+func (a *altoWriter) WritePage(alto string) error {
+	_, err := io.WriteString(a.f, alto)
+	return err
+}
+
+// Close writes the document footer and closes the file.
+func (a *altoWriter) Close() error {
+	if _, err := io.WriteString(a.f, altoFooter); err != nil {
+		a.f.Close()
+		return err
+	}
+	return a.f.Close()
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// converter recognises the text in pages and adds them to documents, sharing
+// its recognisers (and other outputs) between all documents.
+type converter struct {
+	recognisers *recogniserPool
+	// jobs is the number of pages to prepare and recognise at once
+	jobs        int
+	regions     []ocrpdf.Region
+	crop        *cropRect
+	wordsCSV    *wordCSV
+	wordsOut    *wordsWriter
+	hocr        *hocrWriter
+	alto        *altoWriter
+	textOut     *textWriter
+	newDocument func(keywords string) *ocrpdf.Document
+	stop        <-chan struct{}
+
+	// pageno is the number of the last page processed, over all documents
+	pageno     int
+	regionText []pageRegions
+}
+
+// convert adds the given pages to a new document, and writes it to the named
+// output file. If interrupted, the pages processed so far are saved, and true
+// is returned.
+func (c *converter) convert(outfn string, sources []pageSource) bool {
+	var outfile *os.File
+	if !*printText {
+		logvf("Using '%s' as output file.\n", outfn)
+
+		if _, err := os.Stat(outfn); err == nil && !*force {
+			logef("Output file '%s' already exists. Use -force to overwrite.\n",
+				outfn)
+			os.Exit(1)
+		}
+
+		var err error
+		outfile, err = createOutput(outfn)
+		if err != nil {
+			logef("Couldn't create output file '%s': %s\n", outfn, err)
+			os.Exit(1)
+		}
+	}
+
+	doc := c.newDocument(*docKeywords)
+
+	// Images are prepared according to the document settings, but separately
+	// to the document itself, so it can be done in the background
+	prepDoc := c.newDocument(*docKeywords)
+
+	// Iterate through each page, adding each to the document
+	var textWords []string
+	var retained []retainedPage
+	var contents []ocrpdf.ContentsEntry
+	pages, blanks := 0, 0
+	interrupted := false
+	// With several workers, pages are prepared and recognised in the
+	// background. Otherwise, unless disabled, the next page is prepared in
+	// the background whilst text is recognised in the current one
+	var processed <-chan recognisedPage
+	var prepared <-chan preparedPage
+	done := make(chan struct{})
+	defer close(done)
+	if c.jobs > 1 {
+		processed = c.processPages(prepDoc, sources, done)
+	} else if *pipeline {
+		prepared = c.preparePages(prepDoc, sources, done)
+	}
+loop:
+	for _, src := range sources {
+		select {
+		case <-c.stop:
+			interrupted = true
+			break loop
+		default:
+		}
+
+		c.pageno++
+		pageno := c.pageno
+
+		var page recognisedPage
+		switch {
+		case processed != nil:
+			page = <-processed
+		case prepared != nil:
+			page = c.recognise(<-prepared, pageno)
+		default:
+			page = c.recognise(c.prepare(prepDoc, src, pageno), pageno)
+		}
+		original, img, ocrImg := page.original, page.image, page.ocrImage
+		rotation, photo := page.rotation, page.photo
+		words := page.words
+
+		// The page's images are closed as soon as they're no longer needed,
+		// as the garbage collector is too slow to free them in large batches
+		images := []*ocrpdf.Image{original, img, ocrImg}
+
+		// Words are recognised in the processed image, which only differs
+		// from the original in scale, as the original is deskewed with it
+		// (see deskew), so positions are mapped back to the pixels of the
+		// original by scaling them
+		ow, oh, _ := original.Dimensions()
+		pw, _, _ := img.Dimensions()
+		originalScale := float64(ow) / float64(pw)
+
+		if page.blank {
+			closeImages(images, nil)
+			blanks++
+			continue
+		}
+
+		if *printText {
+			fmt.Print(page.text)
+		}
+
+		if c.textOut != nil {
+			// Photos are included without text, so the pages of the text
+			// match those of the document
+			if err := c.textOut.WritePage(page.text); err != nil {
+				logef("Couldn't write text for page %d: %s\n", pageno, err)
+				os.Exit(1)
+			}
+		}
+
+		if c.hocr != nil && !photo {
+			if err := c.hocr.WritePage(page.hocr); err != nil {
+				logef("Couldn't write hOCR for page %d: %s\n", pageno, err)
+				os.Exit(1)
+			}
+		}
+
+		if *jsonDir != "" {
+			w, h, _ := ocrImg.Dimensions()
+			err := writePageJSON(*jsonDir, pageWords{
+				Page:   pageno,
+				Source: src.name(),
+				Width:  w,
+				Height: h,
+				Words:  words,
+				Hash:   fmt.Sprintf("%016x", original.PerceptualHash()),
+			})
+			if err != nil {
+				logef("Couldn't write words for page %d: %s\n", pageno, err)
+				os.Exit(1)
+			}
+		}
+
+		if c.alto != nil && !photo {
+			// Report positions in the pixels of the original image
+			alto := scaleALTO(page.alto, originalScale)
+			if err := c.alto.WritePage(alto); err != nil {
+				logef("Couldn't write ALTO for page %d: %s\n", pageno, err)
+				os.Exit(1)
+			}
+		}
+
+		if c.wordsCSV != nil {
+			// Report positions in the pixels of the original image, as
+			// with --words-out
+			err := c.wordsCSV.WritePage(pageno,
+				ocrpdf.ScaleWords(words, originalScale))
+			if err != nil {
+				logef("Couldn't write words to CSV: %s\n", err)
+				os.Exit(1)
+			}
+		}
+
+		if c.wordsOut != nil {
+			// Report positions in the pixels of the original image, rather
+			// than the (possibly scaled) image text was recognised in
+			width, height := ow, oh
+			if rotation%180 != 0 {
+				width, height = height, width
+			}
+			err := c.wordsOut.WritePage(pageWords{
+				Page:   pageno,
+				Source: src.name(),
+				Width:  width,
+				Height: height,
+				Words:  ocrpdf.ScaleWords(words, originalScale),
+				Hash:   fmt.Sprintf("%016x", original.PerceptualHash()),
+			})
+			if err != nil {
+				logef("Couldn't write words for page %d: %s\n", pageno, err)
+				os.Exit(1)
+			}
+		}
+
+		if *docKeywordsFromText {
+			for _, word := range words {
+				textWords = append(textWords, word.Text)
+			}
+		}
+
+		if len(c.regions) > 0 && !photo {
+			c.regionText = append(c.regionText,
+				newPageRegions(pageno, page.regionWords))
+		}
+
+		if *printText {
+			// No document to add page to
+			closeImages(images, nil)
+			pages++
+			continue
+		}
+
+		// Words are positioned relative to the processed image, so must be
+		// scaled to match the original if it was resized
+		if *imgEmbed == "original" && original != img {
+			words = ocrpdf.ScaleWords(words, originalScale)
+			img = original
+		}
+
+		if *imgGrayscale {
+			img = img.Grayscale()
+			images = append(images, img)
+		}
+
+		// Dithering hinders recognition, so is only applied to the image
+		// stored in the document
+		if *imgDither {
+			img = img.Dither()
+			images = append(images, img)
+		}
+
+		// Add to PDF
+		logvf("[P%d] Adding page to document\n", pageno)
+		doc.SetPageRotation(rotation)
+		err := doc.AddPage(*img, src.name(), words, "")
+		runtime.KeepAlive(img)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		addPageBookmarks(doc, src)
+		contents = append(contents, pageHeadings(words, doc.PageNo())...)
+		pages++
+
+		if *docMaxSize > 0 {
+			// Keep page in case the document needs rebuilding to fit
+			retained = append(retained, retainedPage{img, src, rotation, words})
+			closeImages(images, img)
+		} else {
+			closeImages(images, nil)
+		}
+	}
+
+	if blanks > 0 {
+		logvf("Skipped %d blank pages.\n", blanks)
+	}
+
+	if *printText {
+		return interrupted
+	}
+
+	if interrupted && pages == 0 {
+		logef("No pages were processed, so '%s' was not written.\n", outfn)
+		outfile.Close()
+		os.Remove(outfile.Name())
+		return true
+	}
+	if pages == 0 {
+		logef("All pages were blank, so '%s' was not written.\n", outfn)
+		outfile.Close()
+		os.Remove(outfile.Name())
+		return false
+	}
+
+	keywords := *docKeywords
+	if *docKeywordsFromText && !*docStripMetadata {
+		var truncated bool
+		keywords, truncated = textKeywords(*docKeywords, textWords,
+			*docKeywordsMax)
+		if truncated {
+			logvf("Keywords truncated to %d characters.\n", len(keywords))
+		}
+		doc.SetKeywords(keywords, true)
+	}
+
+	addContents(doc, contents)
+
+	logvf("Writing output to '%s'...\n", outfn)
+
+	// Hash the output as it's written, for the checksum file
+	var out io.Writer = outfile
+	sum := newChecksum(*checksum)
+	if sum != nil {
+		out = io.MultiWriter(outfile, sum)
+	}
+
+	var err error
+	if *docMaxSize > 0 {
+		maxSize := int64(*docMaxSize)
+		var buf *bytes.Buffer
+		buf, err = renderDocument(doc)
+		if err == nil && int64(buf.Len()) > maxSize {
+			logvf("Document is %d bytes, exceeding %d bytes. Rebuilding...\n",
+				buf.Len(), maxSize)
+			buf, err = fitDocument(func() *ocrpdf.Document {
+				return c.newDocument(keywords)
+			}, retained, maxSize)
+		}
+		if err != nil {
+			logef("Couldn't fit document within %d bytes: %s\n", maxSize, err)
+			outfile.Close()
+			os.Remove(outfile.Name())
+			os.Exit(1)
+		}
+		_, err = buf.WriteTo(out)
+	} else {
+		err = doc.Write(out)
+	}
+	if closeErr := outfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		logef("Couldn't write output file '%s': %s\n", outfn, err)
+		os.Remove(outfile.Name())
+		os.Exit(1)
+	}
+
+	// Only replace any existing file now the new one is complete
+	if err := replaceOutput(outfile.Name(), outfn); err != nil {
+		logef("Couldn't write output file '%s': %s\n", outfn, err)
+		os.Remove(outfile.Name())
+		os.Exit(1)
+	}
+
+	if sum != nil {
+		if err := writeChecksum(outfn, *checksum, sum); err != nil {
+			logef("Couldn't write checksum file: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if interrupted {
+		logef("Saved %d of %d pages to '%s'.\n", pages, len(sources), outfn)
+	}
+	return interrupted
+}
+
+// closeImages closes each of the given images, except keep (if not nil).
+func closeImages(images []*ocrpdf.Image, keep *ocrpdf.Image) {
+	for _, img := range images {
+		if img != keep {
+			img.Close()
+		}
+	}
+}
+
+// preparedPage is a page image that is ready to have text recognised in it.
+type preparedPage struct {
+	// original is the image as read (but deskewed along with image), and
+	// image the image after processing
+	original, image *ocrpdf.Image
+	// ocrImage is the image to recognise text in, rotated to be upright
+	ocrImage *ocrpdf.Image
+	rotation int
+	photo    bool
+	// blank is true if the page is to be left out as it's blank
+	blank bool
+}
+
+// preparePages prepares each of the given pages in turn in the background,
+// sending each to the returned channel in order, until done is closed. The
+// pages are numbered following the last page processed.
+func (c *converter) preparePages(doc *ocrpdf.Document, sources []pageSource,
+	done <-chan struct{}) <-chan preparedPage {
+	prepared := make(chan preparedPage)
+	pageno := c.pageno
+	go func() {
+		defer close(prepared)
+		for _, src := range sources {
+			pageno++
+			select {
+			case prepared <- c.prepare(doc, src, pageno):
+			case <-done:
+				return
+			}
+		}
+	}()
+	return prepared
+}
+
+// prepare reads the image of the given page, and processes it according to
+// the settings of the given document, ready for recognition.
+func (c *converter) prepare(doc *ocrpdf.Document, src pageSource,
+	pageno int) preparedPage {
+	fn := src.filename
+
+	// Read image file
+	logvf("[P%d] Reading '%s'...\n", pageno, src.name())
+	img, err := ocrpdf.NewImageFromFileIndex(fn, src.index)
+	if err != nil {
+		logef("Unable to read image from file '%s'\n", src.name())
+		os.Exit(1)
+	}
+
+	if img.Recovered() {
+		logef("[P%d] '%s' is corrupt, so may be incomplete\n",
+			pageno, src.name())
+	}
+
+	w, h, d := img.Dimensions()
+	logvf("[P%d] Read '%s' (%dx%d@%dbpp)\n", pageno, src.name(), w, h, d)
+	if xres, yres := img.GetResolution(); xres > 0 && yres > 0 {
+		logvf("[P%d] Scanned at %dx%d DPI\n", pageno, xres, yres)
+	}
+
+	// Each step replaces img, so the image it replaced is closed straight
+	// away, unless it's still needed as the original, as the garbage
+	// collector is too slow to free them in large batches
+	var original *ocrpdf.Image
+	replace := func(next *ocrpdf.Image) {
+		if next != img && img != original {
+			img.Close()
+		}
+		img = next
+	}
+
+	if *imgRotate != 0 {
+		// Rotate image itself, so the text is recognised upright
+		replace(img.RotateOrth(*imgRotate / 90))
+		w, h, _ := img.Dimensions()
+		logvf("[P%d] Rotated %d degrees (%dx%d)\n", pageno, *imgRotate, w, h)
+	}
+
+	if c.crop != nil {
+		replace(img.Crop(c.crop.x, c.crop.y, c.crop.w, c.crop.h))
+		w, h, _ := img.Dimensions()
+		logvf("[P%d] Cropped to (%dx%d)\n", pageno, w, h)
+	}
+	original = img
+
+	// Blank pages are left out of the document, so need no processing
+	if *imgSkipBlank && img.IsBlank(*imgBlankThreshold) {
+		logvf("[P%d] Appears to be blank, skipping\n", pageno)
+		return preparedPage{original: img, image: img, ocrImage: img,
+			blank: true}
+	}
+
+	if *imgWhiteBalance {
+		replace(img.WhiteBalance())
+	}
+
+	if *imgAutoInvert && img.IsInverted() {
+		logvf("[P%d] Appears to be a negative, inverting\n", pageno)
+		replace(img.Invert())
+	}
+
+	if *imgNormalize {
+		replace(img.NormalizeBackground(ocrpdf.DefaultBackgroundTileSize))
+	}
+
+	if *imgDeskew {
+		deskewed, straightened := deskew(img, original, pageno)
+		if straightened != original {
+			if original != img {
+				original.Close()
+			}
+			original = straightened
+		}
+		replace(deskewed)
+	}
+
+	// Scale to DPI and increase contrast
+	if _, _, depth := img.Dimensions(); depth == 1 && *imgContrast > 0 &&
+		*imgDespeckle == 0 {
+		logvf("[P%d] Black and white image, so contrast can't be "+
+			"enhanced; try --despeckle to clean it up instead\n", pageno)
+	}
+	replace(doc.PrepareImage(img))
+	if *docDPI != 0 && !*docSmooth {
+		w, h, _ := img.Dimensions()
+		logvf("[P%d] Scaled to (%dx%d) @ %ddpi\n", pageno, w, h, *docDPI)
+	}
+	// Don't bother looking for text in photographs
+	photo := false
+	if *imgAutoSkipPhotos {
+		photo = img.IsPhoto()
+		if photo {
+			logvf("[P%d] Classified as photo, skipping OCR\n", pageno)
+		} else {
+			logvf("[P%d] Classified as document\n", pageno)
+		}
+	}
+
+	if *imgSharpen && !photo {
+		replace(img.Sharpen(*imgSharpenRadius, *imgSharpenAmount))
+	}
+
+	if *imgBinarize && !photo {
+		replace(img.Binarize())
+	}
+
+	// Despeckling binarizes, so images that aren't already bi-level are
+	// only despeckled for recognition, leaving the page looking the same
+	despeckleOCR := false
+	if *imgDespeckle > 0 && !photo {
+		if _, _, depth := img.Dimensions(); depth == 1 {
+			replace(img.Despeckle(*imgDespeckle))
+		} else {
+			despeckleOCR = true
+		}
+	}
+
+	// The page displays the image rotated, so recognise text in a
+	// rotated copy, leaving the embedded image data untouched
+	rotation := 0
+	if *imgEXIFRotate {
+		rotation, err = ocrpdf.EXIFRotation(fn)
+		if err == ocrpdf.ErrMirroredOrientation {
+			logef("[P%d] Image is mirrored according to its EXIF "+
+				"orientation, which isn't supported, so it is left as-is\n",
+				pageno)
+		} else if err != nil {
+			logef("[P%d] Couldn't read EXIF orientation: %s\n", pageno, err)
+		} else if rotation != 0 {
+			logvf("[P%d] Rotating %d degrees as per EXIF orientation\n",
+				pageno, rotation)
+		}
+	}
+	ocrImg := img.RotateOrth(rotation / 90)
+
+	replaceOCR := func(next *ocrpdf.Image) {
+		if next != ocrImg && ocrImg != img {
+			ocrImg.Close()
+		}
+		ocrImg = next
+	}
+
+	if despeckleOCR {
+		replaceOCR(ocrImg.Despeckle(*imgDespeckle))
+	}
+
+	if !photo && *imgRemoveLines {
+		// Only the image used for recognition has lines removed, so the
+		// page still looks like the original
+		replaceOCR(ocrImg.RemoveLines(true, true))
+	}
+
+	return preparedPage{original, img, ocrImg, rotation, photo, false}
+}
+
+// deskew corrects any skew in img, returning the deskewed image and the
+// original image straightened by the same angle, so it keeps the same shape
+// as the processed image, and words recognised in one are positioned
+// correctly over the other (such as with --embed original).
+func deskew(img, original *ocrpdf.Image, pageno int) (*ocrpdf.Image,
+	*ocrpdf.Image) {
+	angle, ok := img.SkewAngle(ocrpdf.DefaultDeskewThreshold)
+	if !ok {
+		return img, original
+	}
+	logvf("[P%d] Corrected skew of %.2f degrees\n", pageno, angle)
+	deskewed := img.Rotate(angle)
+	if original == img {
+		return deskewed, deskewed
+	}
+	return deskewed, original.Rotate(angle)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cropRect is a rectangle to crop images to, in pixels.
+type cropRect struct {
+	x, y, w, h int32
+}
+
+// parseCrop parses a rectangle given as "x,y,w,h".
+func parseCrop(spec string) (cropRect, error) {
+	parts := strings.Split(spec, ",")
+	if len(parts) != 4 {
+		return cropRect{}, fmt.Errorf("invalid crop '%s'; expected "+
+			"x,y,width,height", spec)
+	}
+
+	var dims [4]int32
+	for i, part := range parts {
+		v, err := strconv.ParseInt(strings.TrimSpace(part), 10, 32)
+		if err != nil || v < 0 {
+			return cropRect{}, fmt.Errorf("invalid crop dimension '%s'", part)
+		}
+		dims[i] = int32(v)
+	}
+	if dims[2] == 0 || dims[3] == 0 {
+		return cropRect{}, fmt.Errorf("invalid crop '%s'; width and "+
+			"height must be greater than zero", spec)
+	}
+	return cropRect{dims[0], dims[1], dims[2], dims[3]}, nil
+}
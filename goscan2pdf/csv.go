@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// csvHeader names the columns written by wordCSV.
+var csvHeader = []string{
+	"page", "text", "left", "top", "right", "bottom", "confidence",
+}
+
+// wordCSV writes the position of each word on each page as CSV.
+type wordCSV struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// newWordCSV creates the named file and writes the CSV header to it.
+func newWordCSV(fn string) (*wordCSV, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	w := csv.NewWriter(f)
+	if err := w.Write(csvHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &wordCSV{f: f, w: w}, nil
+}
+
+// WritePage writes a row for each of the words on the given page.
+func (c *wordCSV) WritePage(page int, words []ocrpdf.Word) error {
+	p := strconv.Itoa(page)
+	for _, word := range words {
+		err := c.w.Write([]string{
+			p,
+			word.Text,
+			strconv.Itoa(word.Left),
+			strconv.Itoa(word.Top),
+			strconv.Itoa(word.Right),
+			strconv.Itoa(word.Bottom),
+			strconv.FormatFloat(float64(word.Confidence), 'f', 2, 32),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes any buffered rows and closes the file.
+func (c *wordCSV) Close() error {
+	c.w.Flush()
+	if err := c.w.Error(); err != nil {
+		c.f.Close()
+		return err
+	}
+	return c.f.Close()
+}
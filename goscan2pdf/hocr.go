@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// hocrHeader and hocrFooter enclose the hOCR of each page in an HTML
+// document.
+const (
+	hocrHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional//EN"
+    "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
+<html xmlns="http://www.w3.org/1999/xhtml" xml:lang="en" lang="en">
+ <head>
+  <title></title>
+  <meta http-equiv="Content-Type" content="text/html;charset=utf-8"/>
+  <meta name="ocr-system" content="tesseract"/>
+  <meta name="ocr-capabilities" content="ocr_page ocr_carea ocr_par ocr_line ocrx_word"/>
+ </head>
+ <body>
+`
+	hocrFooter = ` </body>
+</html>
+`
+)
+
+// hocrWriter writes the hOCR of each page to a single HTML document.
+type hocrWriter struct {
+	f *os.File
+}
+
+// newHOCRWriter creates the named file and writes the document header to it.
+func newHOCRWriter(fn string) (*hocrWriter, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(f, hocrHeader); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &hocrWriter{f: f}, nil
+}
+
+// WritePage writes the hOCR of a page, as returned by Tess.HOCRText.
+func (h *hocrWriter) WritePage(hocr string) error {
+	_, err := io.WriteString(h.f, hocr)
+	return err
+}
+
+// Close writes the document footer and closes the file.
+func (h *hocrWriter) Close() error {
+	if _, err := io.WriteString(h.f, hocrFooter); err != nil {
+		h.f.Close()
+		return err
+	}
+	return h.f.Close()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// pageWords holds the words recognised on a page, in image pixel
+// coordinates.
+type pageWords struct {
+	Page   int           `json:"page"`
+	Source string        `json:"source"`
+	Width  int32         `json:"width"`
+	Height int32         `json:"height"`
+	Words  []ocrpdf.Word `json:"words"`
+
+	// Hash is the perceptual hash of the original image, in hexadecimal
+	Hash string `json:"phash"`
+}
+
+// writePageJSON writes the words of a page to a file named after the page
+// number (e.g. page-001.json) in the given directory.
+func writePageJSON(dir string, page pageWords) error {
+	if page.Words == nil {
+		page.Words = []ocrpdf.Word{}
+	}
+
+	fn := filepath.Join(dir, fmt.Sprintf("page-%03d.json", page.Page))
+	f, err := os.Create(fn)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(page); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,36 @@
+package main
+
+import "strings"
+
+// textKeywords appends the distinct words of the recognised text to the
+// given keywords, stopping before the result would exceed max bytes. It
+// returns the keywords and whether any words were left out.
+func textKeywords(keywords string, words []string, max int) (string, bool) {
+	seen := make(map[string]bool)
+	for _, kw := range strings.Fields(keywords) {
+		seen[strings.ToLower(kw)] = true
+	}
+
+	result := strings.Fields(keywords)
+	length := len(keywords)
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		key := strings.ToLower(word)
+		if word == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		n := len(word)
+		if len(result) > 0 {
+			n++ // separating space
+		}
+		if length+n > max {
+			return strings.Join(result, " "), true
+		}
+		result = append(result, word)
+		length += n
+	}
+
+	return strings.Join(result, " "), false
+}
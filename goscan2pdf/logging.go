@@ -2,29 +2,33 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 )
 
+// logOut receives all non-error output.
+var logOut io.Writer = os.Stdout
+
 func logv(a ...interface{}) {
 	if verbose {
-		fmt.Println(a...)
+		fmt.Fprintln(logOut, a...)
 	}
 }
 func logvf(format string, a ...interface{}) {
 	if verbose {
-		fmt.Printf(format, a...)
+		fmt.Fprintf(logOut, format, a...)
 	}
 }
 
 func logd(a ...interface{}) {
 	if debug {
-		fmt.Println(a...)
+		fmt.Fprintln(logOut, a...)
 	}
 }
 
 func logdf(format string, a ...interface{}) {
 	if debug {
-		fmt.Printf(format, a...)
+		fmt.Fprintf(logOut, format, a...)
 	}
 }
 
@@ -35,3 +39,7 @@ func loge(a ...interface{}) {
 func logef(format string, a ...interface{}) {
 	fmt.Fprintf(os.Stderr, format, a...)
 }
+
+func logf(format string, a ...interface{}) {
+	fmt.Fprintf(logOut, format, a...)
+}
@@ -2,19 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/johnsto/ocrpdf"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
-const (
-	MM_TO_INCH float64 = 0.039
-)
-
 var (
 	debug   = false
 	verbose = false
@@ -25,49 +24,263 @@ var (
 	output = app.Flag("output", "output filename").Short('o').String()
 	force  = app.Flag("force", "overwrite output file").Short('f').Bool()
 
+	checksum = app.Flag("checksum",
+		"write a checksum of each document to a file alongside it").
+		Enum("sha256")
+
+	pages = app.Flag("pages",
+		"pages to include, e.g. 5-20,30,40-45 (default all)").String()
+
+	perFile = app.Flag("per-file",
+		"create a document per input file (in the output directory, if given)").
+		Bool()
+	pipeline = app.Flag("pipeline",
+		"prepare the next image whilst recognising text in the current one "+
+			"(disable with --no-pipeline)").Default("true").Bool()
+	jobCount = app.Flag("jobs",
+		"number of pages to prepare and recognise text in at once").
+		Default("1").Int()
+	printText = app.Flag("stdout",
+		"print recognised text to stdout instead of creating a PDF").Bool()
+
 	// Tesseract configuration
-	tessData = app.Flag("tess-data", "Tesseract data directory").String()
-	tessLang = app.Flag("tess-lang", "Tesseract language").String()
+	tessData     = app.Flag("tess-data", "Tesseract data directory").String()
+	tessLang     = app.Flag("tess-lang", "Tesseract language").String()
+	tessLangVote = app.Flag("lang-vote",
+		"comma-separated languages to recognise separately, keeping the "+
+			"most confident words").String()
+	tessVars = app.Flag("tess-var",
+		"set a Tesseract variable, as name=value (repeatable)").Strings()
+	tessUserWords = app.Flag("user-words",
+		"file of extra words to recognise, one per line").ExistingFile()
+	tessUserPatterns = app.Flag("user-patterns",
+		"file of patterns of words to recognise, one per line").
+		ExistingFile()
+	tessWhitelist = app.Flag("char-whitelist",
+		"only recognise these characters").String()
+	tessBlacklist = app.Flag("char-blacklist",
+		"never recognise these characters").String()
+	tessOEM = app.Flag("oem", "Tesseract engine mode").
+		Default("default").Enum("default", "legacy", "lstm", "combined")
+	tessPSM = app.Flag("psm",
+		"Tesseract page segmentation mode, e.g. 6 for a uniform block of "+
+			"text, or 11 for sparse text (default automatic)").
+		Default("-1").Int()
+	tessWordSpacing = app.Flag("word-spacing",
+		"how readily text is split into words; tight if words are wrongly "+
+			"joined, loose if wrongly split").
+		Default("normal").Enum("tight", "normal", "loose")
+	tessAssumeDPI = app.Flag("assume-dpi",
+		"image resolution to recognise text at, overriding the image's own").
+		Int()
+
+	// Region configuration
+	regionsFile = app.Flag("regions",
+		"file listing named regions (and whitelists) to recognise separately").
+		ExistingFile()
+	regionsOut = app.Flag("regions-out",
+		"file to write region text to as JSON (default stdout)").String()
+
+	// Word output
+	jsonDir = app.Flag("json-dir",
+		"directory to write each page's words to as JSON").String()
+	csvFile = app.Flag("csv",
+		"file to write the position of every word to as CSV").String()
+	wordsOutFile = app.Flag("words-out",
+		"file to write the words of every page to as JSON, or TSV if it "+
+			"ends in .tsv").String()
+	textOutFile = app.Flag("text-out",
+		"file to write the plain text of every page to, separated by form "+
+			"feeds").String()
+	altoFile = app.Flag("alto",
+		"file to write the layout of the text of every page to as ALTO XML").
+		String()
+	hocrFile = app.Flag("hocr",
+		"file to write the layout of the text of every page to as hOCR").
+		String()
 
 	// Document configuration
-	docSize = app.Flag("size", "document size").
+	groupByFile = app.Flag("group-by-file",
+		"bookmark the pages of each input file under the file's name").Bool()
+	bookmarkPerFile = app.Flag("bookmark-per-file",
+		"bookmark the first page of each input file with the file's name").
+		Bool()
+	docSize = app.Flag("size",
+		"document size, as a name (e.g. a4, letter, legal) or WIDTHxHEIGHT "+
+			"in mm, cm, in or pt (e.g. 210x297mm, 4x6in)").
 		Short('s').Default("a4").String()
 	docOrientation = app.Flag("orientation", "document orientation").
 			Default("auto").Short('r').Enum("auto", "portrait", "landscape")
 	docCompress = app.Flag("compress", "compress document").
 			Default("true").Short('c').Bool()
-	docDPI = app.Flag("dpi", "resize image to DPI (0=disabled)").Default("0").Int()
+	docDPI       = app.Flag("dpi", "resize image to DPI (0=disabled)").Default("0").Int()
+	docSourceDPI = app.Flag("source-dpi",
+		"resolution of scans (after any --dpi scaling), for consistent text "+
+			"sizing (0=unknown)").Default("0").Int()
+	docSmooth = app.Flag("smooth-scaling",
+		"smooth images when resizing to DPI, recognising text at full size").Bool()
+	docTOC = app.Flag("toc",
+		"append a table of contents listing headings found in the text").Bool()
+	docTOCMinSize = app.Flag("toc-min-size",
+		"minimum font size, in points, of headings listed by --toc").
+		Default("16").Int()
+	docView = app.Flag("view", "initial zoom when the document is opened").
+		Default("fit").Enum("fit", "width", "real")
+	docFitAspect = app.Flag("fit-aspect",
+		"lengthen pages to fit long, narrow images such as receipts").Bool()
+	docMargin = app.Flag("margin",
+		"margin, in mm, left around the image on each page").
+		Default("0").Float64()
+	docImageFit = app.Flag("image-fit",
+		"how images are placed on pages: page (shrink page to image), fit, "+
+			"fill, center or actual (size)").
+		Default("page").Enum("page", "fit", "fill", "center", "actual")
+	docMaxSize = app.Flag("max-size",
+		"reduce image quality to fit document within size, e.g. 10MB (0=disabled)").
+		Default("0").Bytes()
 
 	// Document metadata
 	docTitle    = app.Flag("title", "document title").Short('t').String()
 	docSubject  = app.Flag("subject", "document subject").Short('j').String()
 	docKeywords = app.Flag("keywords", "space-separated document keywords").
 			Short('k').String()
+	docKeywordsFromText = app.Flag("keywords-from-text",
+		"add recognised words to the document keywords").Bool()
+	docKeywordsMax = app.Flag("keywords-max",
+		"maximum length of keywords taken from text").
+		Default("2048").Int()
 	docAuthor  = app.Flag("author", "document author").Short('a').String()
 	docCreator = app.Flag("creator", "document creator").
 			Default("ocrpdf").String()
+	docPDFA = app.Flag("pdfa",
+		"PDF/A level the document must conform to for archival (not yet "+
+			"supported, so always exits with an error)").
+		PlaceHolder("LEVEL").Enum("1b")
+	docUserPassword = app.Flag("user-password",
+		"encrypt the document, requiring this password to open it").
+		Envar("GOSCAN2PDF_USER_PASSWORD").String()
+	docOwnerPassword = app.Flag("owner-password",
+		"encrypt the document, requiring this password to lift --deny "+
+			"restrictions (default random)").
+		Envar("GOSCAN2PDF_OWNER_PASSWORD").String()
+	docDeny = app.Flag("deny",
+		"encrypt the document, denying users without the owner password "+
+			"this permission (repeatable)").
+		PlaceHolder("PERMISSION").Enums("print", "copy", "modify", "annotate")
+	docStripMetadata = app.Flag("strip-metadata",
+		"omit all metadata, including file names, overriding other options").
+		Bool()
 
 	// Font settings
 	fontName = app.Flag("font-name", "text font").
 			Default("Arial").String()
+	fontFile = app.Flag("font-file",
+		"TrueType font to embed for the text layer, for non-Latin text").
+		ExistingFile()
 	fontStyle = app.Flag("font-style", "font style, [B]old, [I]talic, [U]nderline").
 			PlaceHolder(" ").Enum("B", "I", "U", "BI", "BU", "IU", "BIU")
-	fontSize = app.Flag("font-size", "OCR layer font size").
-			Default("10").Float()
+	fontSize = app.Flag("font-size",
+		"OCR layer font size, or 'auto' to match each word's height").
+		Default("10").String()
 
 	// Text settings
 	textScaling = app.Flag("scaling", "Scale text to match word boundaries").
 			Default("match").Enum("off", "contain", "match")
+	textLayout = app.Flag("text-layout",
+		"add text per word, per line, or as a block of lines for more "+
+			"reliable selection").
+		Default("cell").Enum("cell", "line-run", "lines")
+	textMinConfidence = app.Flag("min-confidence",
+		"leave words with lower confidence (0-100) out of the text layer").
+		Default("0").Float32()
+	textPreserveStyles = app.Flag("preserve-styles",
+		"match the style (bold, italic, serif or monospace) of each word, "+
+			"where detected").Bool()
+	textRotate = app.Flag("rotate-words",
+		"Rotate text to match the baseline of angled words").Bool()
+	textPadding = app.Flag("box-padding",
+		"expand word boxes by this fraction of their height, making text "+
+			"easier to select").Default("0").Float()
 
 	// Image settings
 	imgContrast = app.Flag("contrast", "automatic contrast amount").
 			Default("0.5").Float()
+	imgWhiteBalance = app.Flag("white-balance",
+		"remove colour casts from colour images").Bool()
+	imgNormalize = app.Flag("normalize",
+		"even out uneven lighting and shadows, such as in photos of pages").
+		Bool()
+	imgCrop = app.Flag("crop",
+		"crop images to the rectangle x,y,width,height (in pixels)").
+		PlaceHolder("X,Y,W,H").String()
+	imgRotate = app.Flag("rotate",
+		"rotate images clockwise by 90, 180 or 270 degrees").
+		Default("0").Int()
+	imgAutoRotate = app.Flag("auto-rotate",
+		"detect the orientation of text and rotate images to correct it").
+		Bool()
+	imgSharpen = app.Flag("sharpen",
+		"sharpen blurry images before recognising text").Bool()
+	imgSharpenRadius = app.Flag("sharpen-radius",
+		"radius, in pixels, of edges enhanced by --sharpen").
+		Default(strconv.Itoa(ocrpdf.DefaultSharpenRadius)).Int()
+	imgSharpenAmount = app.Flag("sharpen-amount",
+		"amount (0-1) by which --sharpen enhances edges").
+		Default(fmt.Sprint(ocrpdf.DefaultSharpenFraction)).Float32()
+	imgDeskew = app.Flag("deskew",
+		"straighten pages that were scanned at an angle").Bool()
+	imgAutoInvert = app.Flag("auto-invert",
+		"invert images that appear to be negatives before recognition").Bool()
+	imgEXIFRotate = app.Flag("exif-rotate",
+		"display JPEGs upright according to their EXIF orientation").Bool()
+	imgEmbed = app.Flag("embed",
+		"image to store in PDF, either the original or as processed for "+
+			"recognition").Default("processed").Enum("original", "processed")
+	imgGrayscale = app.Flag("grayscale",
+		"store colour images in greyscale").Bool()
+	imgBinarize = app.Flag("binarize",
+		"convert images to black and white, for text-heavy scans").Bool()
+	imgDither = app.Flag("dither",
+		"store images in black and white, dithering any shades").Bool()
+	imgDespeckle = app.Flag("despeckle",
+		"remove specks of noise smaller than this many pixels before "+
+			"recognising text (0=disabled)").PlaceHolder("N").Int()
+	imgRemoveLines = app.Flag("remove-lines",
+		"remove ruled lines and boxes before recognising text").Bool()
 	imgFormat = app.Flag("format", "format to use when storing images in PDF").
-			Default("jpeg").Enum("jpeg", "png")
-	imgJPEGLevel = app.Flag("jpeg-level", "JPEG compression level").
-			Default(strconv.Itoa(ocrpdf.DefaultJPEGCompression)).Int()
+			Default("jpeg").Enum("jpeg", "png", "smart", "auto")
+	imgJPEGQuality = app.Flag("jpeg-quality",
+		"quality (1-100) of images stored as JPEG").
+		Default(strconv.Itoa(ocrpdf.DefaultJPEGCompression)).Int()
+	imgJPEGLevel = app.Flag("jpeg-level",
+		"deprecated alias of --jpeg-quality").Hidden().Int()
+	imgProgressive = app.Flag("progressive",
+		"store JPEG images progressively, so they appear sooner when "+
+			"viewed online").Bool()
+	imgDumpDir = app.Flag("dump-images",
+		"directory to write each page's embedded image to").String()
+	imgMaxColors = app.Flag("max-colors",
+		"max colours for indexed PNG storage with --format=smart").
+		Default(strconv.Itoa(ocrpdf.DefaultMaxIndexedColors)).Int()
+	imgSkipBlank = app.Flag("skip-blank",
+		"leave blank pages, such as the backs of duplex scans, out of the "+
+			"document").Bool()
+	imgBlankThreshold = app.Flag("blank-threshold",
+		"fraction of a page (0-1) that must have content for --skip-blank "+
+			"to keep it").
+		Default(fmt.Sprint(ocrpdf.DefaultBlankThreshold)).Float32()
+	imgAutoSkipPhotos = app.Flag("auto-skip-photos",
+		"skip text recognition on pages that appear to be photographs").Bool()
 )
 
+// engineModes maps the values of --oem to Tesseract engine modes.
+var engineModes = map[string]ocrpdf.EngineMode{
+	"default":  ocrpdf.DefaultEngine,
+	"legacy":   ocrpdf.LegacyEngine,
+	"lstm":     ocrpdf.LSTMEngine,
+	"combined": ocrpdf.CombinedEngine,
+}
+
 func init() {
 	app.Flag("debug", "enable debug mode").Short('d').BoolVar(&debug)
 	app.Flag("verbose", "enable verbose mode").Short('v').BoolVar(&verbose)
@@ -76,31 +289,267 @@ func init() {
 func main() {
 	kingpin.MustParse(app.Parse(os.Args[1:]))
 
+	if *printText {
+		// Keep stdout clear for the recognised text
+		logOut = os.Stderr
+	}
+
 	logv("Initialising Leptonica...")
-	ocrpdf.JPEGCompression = *imgJPEGLevel
+	if *imgJPEGLevel != 0 {
+		*imgJPEGQuality = *imgJPEGLevel
+	}
+	if *imgJPEGQuality < 1 || *imgJPEGQuality > 100 {
+		logef("Invalid JPEG quality %d, must be 1-100\n", *imgJPEGQuality)
+		os.Exit(1)
+	}
+	ocrpdf.MaxIndexedColors = *imgMaxColors
 
 	logv("Initialising Tesseract...")
-	tess, err := ocrpdf.NewTess(*tessData, *tessLang)
+	lang := *tessLang
+	var voteLangs []string
+	if *tessLangVote != "" {
+		voteLangs = strings.Split(*tessLangVote, ",")
+		lang, voteLangs = voteLangs[0], voteLangs[1:]
+	}
+	oem := engineModes[*tessOEM]
+
+	// User words and patterns are only read as Tesseract is initialised
+	initVars := map[string]string{}
+	if *tessUserWords != "" {
+		initVars["user_words_file"] = *tessUserWords
+	}
+	if *tessUserPatterns != "" {
+		initVars["user_patterns_file"] = *tessUserPatterns
+	}
+
+	// Tess instances can't be shared between workers, so each language has a
+	// pool of instances, created as workers need them
+	newTess := func(lang string) (*ocrpdf.Tess, error) {
+		t, err := ocrpdf.NewTessWithVariables(*tessData, lang, oem, initVars)
+		if err != nil {
+			return nil, fmt.Errorf("'%s': %s", lang, err)
+		}
+		if err := configureTess(t); err != nil {
+			t.Close()
+			return nil, err
+		}
+		return t, nil
+	}
+	newPool := func(lang string) *ocrpdf.TessPool {
+		return ocrpdf.NewTessPool(*jobCount, func() (*ocrpdf.Tess, error) {
+			return newTess(lang)
+		})
+	}
 
+	if *docMargin < 0 {
+		logef("Invalid margin %g, must not be negative\n", *docMargin)
+		os.Exit(1)
+	}
+	if *jobCount < 1 {
+		logef("Invalid number of jobs %d, must be at least 1\n", *jobCount)
+		os.Exit(1)
+	}
+	recognisers := &recogniserPool{tess: newPool(lang)}
+	for _, lang := range voteLangs {
+		recognisers.voters = append(recognisers.voters, newPool(lang))
+	}
+	// Initialise a recogniser up front, so problems are reported before any
+	// pages are converted. It's kept in the pool for the first page.
+	r, err := recognisers.get()
 	if err != nil {
 		logef("could not initialise Tesseract: %s\n", err)
 		os.Exit(1)
 	}
+	recognisers.put(r)
+
+	var regions []ocrpdf.Region
+	if *regionsFile != "" {
+		regions, err = readRegions(*regionsFile)
+		if err != nil {
+			logef("Couldn't read regions from '%s': %s\n", *regionsFile, err)
+			os.Exit(1)
+		}
+	}
 
-	doc := ocrpdf.NewDocument(*docSize)
-	doc.SetDebug(debug)
-	doc.SetFont(*fontName, *fontStyle, *fontSize)
-	doc.SetTextScaling(ocrpdf.TextScaling(*textScaling))
-	doc.SetTitle(*docTitle, true)
-	doc.SetSubject(*docSubject, true)
-	doc.SetKeywords(*docKeywords, true)
-	doc.SetAuthor(*docAuthor, true)
-	doc.SetCompression(*docCompress)
-	doc.SetOrientation(ocrpdf.Orientation(*docOrientation))
+	if *jsonDir != "" {
+		if err := os.MkdirAll(*jsonDir, 0777); err != nil {
+			logef("Couldn't create JSON directory '%s': %s\n", *jsonDir, err)
+			os.Exit(1)
+		}
+	}
+
+	var wordsCSV *wordCSV
+	if *csvFile != "" {
+		wordsCSV, err = newWordCSV(*csvFile)
+		if err != nil {
+			logef("Couldn't create CSV file '%s': %s\n", *csvFile, err)
+			os.Exit(1)
+		}
+	}
+
+	var wordsOut *wordsWriter
+	if *wordsOutFile != "" {
+		wordsOut, err = newWordsWriter(*wordsOutFile)
+		if err != nil {
+			logef("Couldn't create words file '%s': %s\n", *wordsOutFile,
+				err)
+			os.Exit(1)
+		}
+	}
+
+	var hocr *hocrWriter
+	if *hocrFile != "" {
+		hocr, err = newHOCRWriter(*hocrFile)
+		if err != nil {
+			logef("Couldn't create hOCR file '%s': %s\n", *hocrFile, err)
+			os.Exit(1)
+		}
+	}
+
+	var alto *altoWriter
+	if *altoFile != "" {
+		alto, err = newALTOWriter(*altoFile)
+		if err != nil {
+			logef("Couldn't create ALTO file '%s': %s\n", *altoFile, err)
+			os.Exit(1)
+		}
+	}
+
+	var textOut *textWriter
+	if *textOutFile != "" {
+		textOut, err = newTextWriter(*textOutFile)
+		if err != nil {
+			logef("Couldn't create text file '%s': %s\n", *textOutFile, err)
+			os.Exit(1)
+		}
+	}
+
+	var imageHook ocrpdf.ImageHook
+	if *imgDumpDir != "" {
+		if err := os.MkdirAll(*imgDumpDir, 0777); err != nil {
+			logef("Couldn't create image directory '%s': %s\n",
+				*imgDumpDir, err)
+			os.Exit(1)
+		}
+		imageHook = func(page int, data []byte, format string) {
+			fn := filepath.Join(*imgDumpDir,
+				fmt.Sprintf("page-%03d.%s", page, format))
+			logvf("[P%d] Writing embedded image to '%s'\n", page, fn)
+			if err := ioutil.WriteFile(fn, data, 0666); err != nil {
+				logef("Couldn't write embedded image '%s': %s\n", fn, err)
+			}
+		}
+	}
+
+	autoFontSize := *fontSize == "auto"
+	fontPoints := 10.0
+	if !autoFontSize {
+		fontPoints, err = strconv.ParseFloat(*fontSize, 64)
+		if err != nil {
+			logef("Invalid font size '%s'\n", *fontSize)
+			os.Exit(1)
+		}
+	}
+
+	if *imgRotate != 0 && *imgRotate != 90 && *imgRotate != 180 &&
+		*imgRotate != 270 {
+		logef("Invalid rotation %d, must be 90, 180 or 270\n", *imgRotate)
+		os.Exit(1)
+	}
+
+	if *fontFile != "" &&
+		ocrpdf.TextLayout(*textLayout) != ocrpdf.CellTextLayout {
+		logef("--text-layout %s isn't supported with --font-file, "+
+			"so cell layout will be used\n", *textLayout)
+	}
+
+	var crop *cropRect
+	if *imgCrop != "" {
+		rect, err := parseCrop(*imgCrop)
+		if err != nil {
+			logef("%s\n", err)
+			os.Exit(1)
+		}
+		crop = &rect
+	}
+
+	fitAspect := 0.0
+	if *docFitAspect {
+		fitAspect = ocrpdf.DefaultFitAspectRatio
+	}
+
+	encrypt := *docUserPassword != "" || *docOwnerPassword != "" ||
+		len(*docDeny) > 0
+	permissions := ocrpdf.PermitAll
+	for _, deny := range *docDeny {
+		switch deny {
+		case "print":
+			permissions &^= ocrpdf.PermitPrint
+		case "copy":
+			permissions &^= ocrpdf.PermitCopy
+		case "modify":
+			permissions &^= ocrpdf.PermitModify
+		case "annotate":
+			permissions &^= ocrpdf.PermitAnnotate
+		}
+	}
+
+	newDocument := func(keywords string) *ocrpdf.Document {
+		doc := ocrpdf.NewDocumentWithOptions(*docSize,
+			ocrpdf.WithDebug(debug),
+			ocrpdf.WithFont(*fontName, *fontStyle, fontPoints),
+			ocrpdf.WithFontFile(*fontFile),
+			ocrpdf.WithAutoFontSize(autoFontSize),
+			ocrpdf.WithPreserveStyles(*textPreserveStyles),
+			ocrpdf.WithTextScaling(ocrpdf.TextScaling(*textScaling)),
+			ocrpdf.WithTextLayout(ocrpdf.TextLayout(*textLayout)),
+			ocrpdf.WithRotateWords(*textRotate),
+			ocrpdf.WithMinConfidence(*textMinConfidence),
+			ocrpdf.WithCompression(*docCompress),
+			ocrpdf.WithOrientation(ocrpdf.Orientation(*docOrientation)),
+			ocrpdf.WithImageFormat(*imgFormat),
+			ocrpdf.WithJPEGQuality(*imgJPEGQuality, *imgProgressive),
+			ocrpdf.WithImageHook(imageHook),
+			ocrpdf.WithContrast(*imgContrast),
+			ocrpdf.WithDPI(*docDPI),
+			ocrpdf.WithSourceDPI(*docSourceDPI),
+			ocrpdf.WithSmoothScaling(*docSmooth),
+			ocrpdf.WithFitAspect(fitAspect),
+			ocrpdf.WithBoxPadding(*textPadding),
+			ocrpdf.WithPageMargins(*docMargin, *docMargin, *docMargin,
+				*docMargin),
+			ocrpdf.WithImageFit(ocrpdf.ImageFit(*docImageFit)),
+			ocrpdf.WithDisplayMode(*docView, "single"),
+			ocrpdf.WithPDFA(*docPDFA))
+		if encrypt {
+			doc.SetEncryption(*docUserPassword, *docOwnerPassword,
+				permissions)
+		}
+		if *docStripMetadata {
+			doc.ClearMetadata()
+			return doc
+		}
+		doc.SetTitle(*docTitle, true)
+		doc.SetSubject(*docSubject, true)
+		doc.SetKeywords(keywords, true)
+		doc.SetAuthor(*docAuthor, true)
+		doc.SetCreator(*docCreator, true)
+		return doc
+	}
+
+	// Check the document settings before recognising any text
+	if err := newDocument("").Error(); err != nil {
+		logef("Couldn't create document: %s\n", err)
+		os.Exit(1)
+	}
 
 	outfn := *output
-	infns := *files
-	if outfn == "" {
+	infns, err := expandGlobs(*files)
+	if err != nil {
+		logef("%s\n", err)
+		os.Exit(1)
+	}
+	if outfn == "" && !*printText && !*perFile {
 		// Search input files for a .pdf file
 		pos := -1
 		for i, fn := range infns {
@@ -122,76 +571,165 @@ func main() {
 			infns = append(infns[:pos], infns[pos+1:]...)
 		} else {
 			// No .pdf file on command line, so use name of first input instead
-			outfn = infns[0]
-			ext := filepath.Ext(outfn)
-			outfn = strings.TrimRight(outfn, ext) + ".pdf"
+			outfn = pdfFilename(infns[0])
 		}
 	}
 
-	logvf("Using '%s' as output file.\n", outfn)
-
-	openFlags := os.O_RDWR | os.O_CREATE
-	if *force {
-		openFlags |= os.O_TRUNC
-	} else {
-		openFlags |= os.O_EXCL
+	var ranges []pageRange
+	if *pages != "" {
+		ranges, err = parsePageRanges(*pages)
+		if err != nil {
+			logef("%s\n", err)
+			os.Exit(1)
+		}
 	}
 
-	outfile, err := os.OpenFile(outfn, openFlags, 0666)
+	var jobs []conversion
+	if *perFile {
+		if *output != "" {
+			if err := os.MkdirAll(*output, 0777); err != nil {
+				logef("Couldn't create output directory '%s': %s\n",
+					*output, err)
+				os.Exit(1)
+			}
+		}
 
-	if os.IsExist(err) {
-		logef("Output file '%s' already exists. Use -force to overwrite.\n",
-			outfn)
-		os.Exit(1)
-	} else if err != nil {
-		logef("Couldn't create output file '%s': %s\n", outfn, err)
-		os.Exit(1)
+		// Create a document from each file
+		for _, fn := range infns {
+			sources, err := expandSources([]string{fn})
+			if err != nil {
+				logef("Unable to read input files: %s\n", err)
+				os.Exit(1)
+			}
+			if ranges != nil {
+				sources, err = selectPages(sources, ranges)
+				if err != nil {
+					logef("Invalid pages for '%s': %s\n", fn, err)
+					os.Exit(1)
+				}
+			}
+			jobs = append(jobs, conversion{perFileOutput(fn), sources})
+		}
+	} else {
+		// Expand multi-page files into individual pages
+		sources, err := expandSources(infns)
+		if err != nil {
+			logef("Unable to read input files: %s\n", err)
+			os.Exit(1)
+		}
+		if ranges != nil {
+			sources, err = selectPages(sources, ranges)
+			if err != nil {
+				logef("Invalid pages: %s\n", err)
+				os.Exit(1)
+			}
+		}
+		jobs = append(jobs, conversion{outfn, sources})
 	}
 
-	// Iterate through each filename specified, adding a page for each
-	for i, fn := range infns {
-		pageno := i + 1
+	// On interrupt, stop after the current page and save what we have so far
+	stop := make(chan struct{})
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupt
+		// Restore default behaviour so a second signal exits immediately
+		signal.Stop(interrupt)
+		logef("\nReceived %s, finishing current page...\n", sig)
+		close(stop)
+	}()
+
+	c := &converter{
+		recognisers: recognisers,
+		jobs:        *jobCount,
+		regions:     regions,
+		crop:        crop,
+		wordsCSV:    wordsCSV,
+		wordsOut:    wordsOut,
+		hocr:        hocr,
+		textOut:     textOut,
+		alto:        alto,
+		newDocument: newDocument,
+		stop:        stop,
+	}
+	interrupted := false
+	for _, job := range jobs {
+		if c.convert(job.outfn, job.sources) {
+			interrupted = true
+			break
+		}
+	}
 
-		// Read image file
-		logvf("[P%d] Reading '%s'...\n", pageno, fn)
-		img, err := ocrpdf.NewImageFromFile(fn)
-		if err != nil {
-			logef("Unable to read image from file '%s'\n", fn)
+	if wordsCSV != nil {
+		if err := wordsCSV.Close(); err != nil {
+			logef("Couldn't write CSV file '%s': %s\n", *csvFile, err)
 			os.Exit(1)
 		}
+	}
 
-		w, h, d := img.Dimensions()
-		logvf("[P%d] Read '%s' (%dx%d@%dbpp)\n", pageno, fn, w, h, d)
+	if wordsOut != nil {
+		if err := wordsOut.Close(); err != nil {
+			logef("Couldn't write words file '%s': %s\n", *wordsOutFile,
+				err)
+			os.Exit(1)
+		}
+	}
 
-		if *docDPI != 0 {
-			// Resize image to requested d/in (rather, d/mm)
-			dpmm := float64(*docDPI) * MM_TO_INCH
-			pw, ph := doc.GetPageSize()
-			w, h := int32(pw*dpmm), int32(ph*dpmm)
-			logvf("[P%d] Scaling down to (%dx%d) @ %ddpi\n",
-				pageno, w, h, *docDPI)
-			img = img.ScaleDown(w, h)
+	if alto != nil {
+		if err := alto.Close(); err != nil {
+			logef("Couldn't write ALTO file '%s': %s\n", *altoFile, err)
+			os.Exit(1)
 		}
+	}
 
-		// Increase contrast
-		img = img.Adjust(float32(*imgContrast))
-		tess.SetImagePix(img.CPIX())
+	if textOut != nil {
+		if err := textOut.Close(); err != nil {
+			logef("Couldn't write text file '%s': %s\n", *textOutFile, err)
+			os.Exit(1)
+		}
+	}
 
-		// Extract words
-		logvf("[P%d] Finding text...", pageno)
-		words := tess.Words()
-		logvf(" %d words found.\n", len(words))
+	if hocr != nil {
+		if err := hocr.Close(); err != nil {
+			logef("Couldn't write hOCR file '%s': %s\n", *hocrFile, err)
+			os.Exit(1)
+		}
+	}
 
-		// Add to PDF
-		logvf("[P%d] Adding page to document\n", pageno)
-		err = doc.AddPage(*img, fn, words, *imgFormat)
-		if err != nil {
-			fmt.Println(err)
+	if len(regions) > 0 {
+		if err := writeRegions(*regionsOut, c.regionText); err != nil {
+			logef("Couldn't write region text: %s\n", err)
 			os.Exit(1)
 		}
 	}
 
-	logvf("Writing output to '%s'...\n", outfn)
+	if err := recognisers.close(); err != nil {
+		logef("Couldn't close Tesseract: %s\n", err)
+	}
 
-	doc.OutputAndClose(outfile)
+	if interrupted {
+		os.Exit(1)
+	}
+}
+
+// conversion describes a document to be created from a list of pages.
+type conversion struct {
+	outfn   string
+	sources []pageSource
+}
+
+// pdfFilename returns the given filename with its extension replaced by .pdf.
+func pdfFilename(fn string) string {
+	return strings.TrimSuffix(fn, filepath.Ext(fn)) + ".pdf"
+}
+
+// perFileOutput returns the name of the document created from the given
+// input file when creating a document per file. Documents are created
+// alongside their input files, or in the output directory if one is given.
+func perFileOutput(fn string) string {
+	outfn := pdfFilename(fn)
+	if *output != "" {
+		return filepath.Join(*output, filepath.Base(outfn))
+	}
+	return outfn
 }
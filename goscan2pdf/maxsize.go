@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// minJPEGQuality is the lowest JPEG quality tried when fitting a document
+// within the maximum size.
+const minJPEGQuality = 10
+
+// fitQualityStep is the amount by which JPEG quality is reduced each attempt.
+const fitQualityStep = 10
+
+// fitScales are the image scales tried in turn, once the JPEG quality has been
+// reduced to minJPEGQuality.
+var fitScales = []float64{0.75, 0.5, 0.35, 0.25}
+
+// retainedPage holds a processed page, so that the document can be rebuilt.
+type retainedPage struct {
+	image    *ocrpdf.Image
+	source   pageSource
+	rotation int
+	words    []ocrpdf.Word
+}
+
+// renderDocument writes the complete document to a buffer.
+func renderDocument(doc *ocrpdf.Document) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	if err := doc.Write(&buf); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// buildDocument adds the given pages to a document created by newDoc, with
+// images scaled by the given factor, and renders it to a buffer.
+func buildDocument(newDoc func() *ocrpdf.Document, pages []retainedPage,
+	scale float64) (*bytes.Buffer, error) {
+	doc := newDoc()
+	var contents []ocrpdf.ContentsEntry
+	for _, page := range pages {
+		img, words := page.image, page.words
+		if scale != 1 {
+			w, h, _ := img.Dimensions()
+			img = img.Scale(int32(float64(w)*scale), int32(float64(h)*scale))
+			words = ocrpdf.ScaleWords(words, scale)
+		}
+		doc.SetPageRotation(page.rotation)
+		err := doc.AddPage(*img, page.source.name(), words, "")
+		runtime.KeepAlive(img)
+		if img != page.image {
+			// Each attempt scales the pages afresh
+			img.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		addPageBookmarks(doc, page.source)
+		contents = append(contents, pageHeadings(page.words, doc.PageNo())...)
+	}
+	addContents(doc, contents)
+	return renderDocument(doc)
+}
+
+// fitDocument rebuilds the document with progressively lower JPEG quality,
+// and then progressively smaller images, until it is no larger than maxSize
+// bytes. Returns an error if the document can't be made small enough.
+func fitDocument(newDoc func() *ocrpdf.Document, pages []retainedPage,
+	maxSize int64) (*bytes.Buffer, error) {
+	attempt := func(quality int, scale float64) (*bytes.Buffer, error) {
+		buf, err := buildDocument(func() *ocrpdf.Document {
+			doc := newDoc()
+			doc.SetJPEGQuality(quality, *imgProgressive)
+			return doc
+		}, pages, scale)
+		if err != nil {
+			return nil, err
+		}
+		logvf("Quality %d at %.0f%% scale: %d bytes\n",
+			quality, 100*scale, buf.Len())
+		return buf, nil
+	}
+
+	fitted := func(buf *bytes.Buffer, quality int, scale float64) bool {
+		if int64(buf.Len()) > maxSize {
+			return false
+		}
+		logf("Fitted document in %d bytes using JPEG quality %d "+
+			"at %.0f%% scale.\n", buf.Len(), quality, 100*scale)
+		return true
+	}
+
+	// Reduce quality first, as it's less destructive than reducing scale
+	quality := *imgJPEGQuality
+	for quality > minJPEGQuality {
+		quality -= fitQualityStep
+		if quality < minJPEGQuality {
+			quality = minJPEGQuality
+		}
+		buf, err := attempt(quality, 1)
+		if err != nil {
+			return nil, err
+		}
+		if fitted(buf, quality, 1) {
+			return buf, nil
+		}
+	}
+
+	var size int
+	for _, scale := range fitScales {
+		buf, err := attempt(quality, scale)
+		if err != nil {
+			return nil, err
+		}
+		if fitted(buf, quality, scale) {
+			return buf, nil
+		}
+		size = buf.Len()
+	}
+
+	return nil, fmt.Errorf("document is still %d bytes at JPEG quality %d "+
+		"and %.0f%% scale", size, quality, 100*fitScales[len(fitScales)-1])
+}
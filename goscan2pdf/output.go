@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// createOutput creates a temporary file alongside the named output file, to
+// which the output is written before being moved into place by
+// replaceOutput. This ensures an existing output file is only replaced once
+// the new one is complete, and a partially written file never takes its
+// place.
+func createOutput(fn string) (*os.File, error) {
+	tmpfn := filepath.Join(filepath.Dir(fn),
+		fmt.Sprintf(".%s.%d.tmp", filepath.Base(fn), os.Getpid()))
+	return os.OpenFile(tmpfn, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+}
+
+// newChecksum returns a hash for computing the named type of checksum of the
+// output, or nil if no checksum is required.
+func newChecksum(algorithm string) hash.Hash {
+	switch algorithm {
+	case "sha256":
+		return sha256.New()
+	}
+	return nil
+}
+
+// writeChecksum writes the sum of the given hash to a file named after the
+// output file with the checksum type as an extension (e.g. doc.pdf.sha256),
+// in the format used by tools such as sha256sum, so it can be verified with
+// `sha256sum -c`.
+func writeChecksum(fn, algorithm string, sum hash.Hash) error {
+	line := fmt.Sprintf("%x  %s\n", sum.Sum(nil), filepath.Base(fn))
+	return ioutil.WriteFile(fn+"."+algorithm, []byte(line), 0666)
+}
+
+// replaceOutput moves the (closed) temporary file created by createOutput
+// over the named output file. Should the file somehow be on a different
+// filesystem, it is copied instead, and the temporary file removed.
+func replaceOutput(tmpfn, fn string) error {
+	err := os.Rename(tmpfn, fn)
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return err
+	}
+
+	src, err := os.Open(tmpfn)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(fn, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(tmpfn)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goscan2pdf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fn := filepath.Join(dir, "out.pdf")
+	if err := ioutil.WriteFile(fn, []byte("old"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := createOutput(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpfn := f.Name()
+	if _, err := f.WriteString("new"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := replaceOutput(tmpfn, fn); err != nil {
+		t.Fatalf("replaceOutput returned %v, expected nil", err)
+	}
+
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new" {
+		t.Errorf("output contains %q, expected %q", data, "new")
+	}
+	if _, err := os.Stat(tmpfn); !os.IsNotExist(err) {
+		t.Errorf("temporary file %s was not removed", tmpfn)
+	}
+}
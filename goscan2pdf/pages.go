@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pageRange is an inclusive range of page numbers, counting from 1.
+type pageRange struct {
+	first, last int
+}
+
+// parsePageRanges parses a comma-separated list of page numbers and ranges,
+// such as "5-20,30,40-45".
+func parsePageRanges(spec string) ([]pageRange, error) {
+	var ranges []pageRange
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+		}
+
+		var r pageRange
+		var err1, err2 error
+		r.first, err1 = strconv.Atoi(strings.TrimSpace(first))
+		r.last, err2 = strconv.Atoi(strings.TrimSpace(last))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid page range '%s'", part)
+		}
+		if r.first < 1 || r.last < r.first {
+			return nil, fmt.Errorf("invalid page range '%s'; pages are "+
+				"numbered from 1, and ranges must be in ascending order", part)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// selectPages returns the sources of the pages within the given ranges, in
+// their original order. Returns an error if a range refers to pages beyond
+// the last.
+func selectPages(sources []pageSource, ranges []pageRange) ([]pageSource,
+	error) {
+	for _, r := range ranges {
+		if r.last > len(sources) {
+			return nil, fmt.Errorf("page range %d-%d is beyond the last "+
+				"page (%d)", r.first, r.last, len(sources))
+		}
+	}
+
+	var selected []pageSource
+	for i, src := range sources {
+		for _, r := range ranges {
+			if i+1 >= r.first && i+1 <= r.last {
+				selected = append(selected, src)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
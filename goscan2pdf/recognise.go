@@ -0,0 +1,331 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// minOrientationConfidence is the minimum confidence in a detected
+// orientation for --auto-rotate to correct it.
+const minOrientationConfidence = 2.0
+
+// recogniser is a set of Tess instances that recognise the text in a page:
+// the main instance, and one for each of the other voting languages. A Tess
+// instance can't be used concurrently, so each worker borrows its own
+// recogniser from a recogniserPool for each page.
+type recogniser struct {
+	tess   *ocrpdf.Tess
+	voters []*ocrpdf.Tess
+}
+
+// configureTess applies the Tesseract settings given on the command line to
+// a newly created Tess instance.
+func configureTess(t *ocrpdf.Tess) error {
+	err := t.SetWordSpacing(ocrpdf.WordSpacing(*tessWordSpacing))
+	if err != nil {
+		return fmt.Errorf("couldn't set word spacing: %s", err)
+	}
+	for _, v := range *tessVars {
+		nameValue := strings.SplitN(v, "=", 2)
+		if len(nameValue) != 2 {
+			return fmt.Errorf("invalid Tesseract variable '%s', expected "+
+				"name=value", v)
+		}
+		if err := t.SetVariable(nameValue[0], nameValue[1]); err != nil {
+			return err
+		}
+	}
+	if *tessWhitelist != "" {
+		err := t.SetVariable("tessedit_char_whitelist", *tessWhitelist)
+		if err != nil {
+			return err
+		}
+	}
+	if *tessBlacklist != "" {
+		err := t.SetVariable("tessedit_char_blacklist", *tessBlacklist)
+		if err != nil {
+			return err
+		}
+	}
+	if *tessPSM >= 0 {
+		if err := t.SetPageSegMode(*tessPSM); err != nil {
+			return fmt.Errorf("couldn't set page segmentation mode: %s", err)
+		}
+	}
+	return nil
+}
+
+// recogniserPool lends out recognisers, taking their Tess instances from a
+// pool for each language, so instances are only created when workers need
+// them, and are then reused for later pages.
+type recogniserPool struct {
+	tess   *ocrpdf.TessPool
+	voters []*ocrpdf.TessPool
+}
+
+// get returns a recogniser, which must be returned with put when done.
+func (p *recogniserPool) get() (*recogniser, error) {
+	tess, err := p.tess.Get()
+	if err != nil {
+		return nil, err
+	}
+	r := &recogniser{tess: tess}
+	for _, pool := range p.voters {
+		voter, err := pool.Get()
+		if err != nil {
+			p.put(r)
+			return nil, err
+		}
+		r.voters = append(r.voters, voter)
+	}
+	return r, nil
+}
+
+// put returns a recogniser obtained from get to the pool.
+func (p *recogniserPool) put(r *recogniser) {
+	p.tess.Put(r.tess)
+	for i, voter := range r.voters {
+		p.voters[i].Put(voter)
+	}
+}
+
+// close closes the idle Tess instances of each language.
+func (p *recogniserPool) close() error {
+	err := p.tess.Close()
+	for _, pool := range p.voters {
+		if cerr := pool.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// recognisedPage is a prepared page along with the text recognised in it.
+type recognisedPage struct {
+	preparedPage
+	words []ocrpdf.Word
+	// text, hocr and alto are the plain text, hOCR and ALTO of the page, if
+	// requested
+	text, hocr, alto string
+	regionWords      map[string][]ocrpdf.Word
+}
+
+// recognise recognises the text in the given page using a recogniser
+// borrowed from the pool, correcting the orientation of the page first, if
+// enabled. Photos are left unrecognised.
+func (c *converter) recognise(page preparedPage, pageno int) recognisedPage {
+	recognised := recognisedPage{preparedPage: page}
+	if page.photo || page.blank {
+		return recognised
+	}
+
+	r, err := c.recognisers.get()
+	if err != nil {
+		logef("Couldn't initialise Tesseract: %s\n", err)
+		os.Exit(1)
+	}
+	defer c.recognisers.put(r)
+
+	// Don't let recognition of previous pages affect this one
+	r.tess.Clear()
+	for _, voter := range r.voters {
+		voter.Clear()
+	}
+
+	r.tess.SetImagePix(page.ocrImage.CPIX())
+	if *tessAssumeDPI > 0 {
+		r.tess.SetSourceResolution(*tessAssumeDPI)
+	}
+
+	if *imgAutoRotate {
+		rotations, conf, err := r.tess.DetectOrientation()
+		if err != nil {
+			logef("[P%d] Couldn't detect orientation: %s\n", pageno, err)
+		} else if rotations != 0 && conf >= minOrientationConfidence {
+			logvf("[P%d] Rotating %d degrees to correct orientation\n",
+				pageno, rotations*90)
+			rotated, replaced := rotatePage(page, rotations)
+			page = rotated
+			recognised.preparedPage = page
+			r.tess.SetImagePix(page.ocrImage.CPIX())
+			if *tessAssumeDPI > 0 {
+				r.tess.SetSourceResolution(*tessAssumeDPI)
+			}
+			closeImages(replaced, nil)
+		}
+	}
+
+	logvf("[P%d] Finding text...\n", pageno)
+	words, err := r.tess.Words()
+	if err != nil {
+		logef("Couldn't recognise text: %s\n", err)
+		os.Exit(1)
+	}
+	for _, voter := range r.voters {
+		// Keep the most confident words of each language
+		voter.SetImagePix(page.ocrImage.CPIX())
+		if *tessAssumeDPI > 0 {
+			voter.SetSourceResolution(*tessAssumeDPI)
+		}
+		voteWords, err := voter.Words()
+		if err != nil {
+			logef("Couldn't recognise text: %s\n", err)
+			os.Exit(1)
+		}
+		words = ocrpdf.VoteWords(words, voteWords)
+	}
+	recognised.words = words
+	logvf("[P%d] %d words found.\n", pageno, len(words))
+	if len(words) > 0 {
+		var total float32
+		for _, word := range words {
+			total += word.Confidence
+		}
+		logvf("[P%d] Average confidence %.1f%%\n", pageno,
+			total/float32(len(words)))
+	}
+	if _, skipped := ocrpdf.FilterWords(words,
+		*textMinConfidence); skipped > 0 {
+		logvf("[P%d] %d low confidence or empty words left out of "+
+			"text layer\n", pageno, skipped)
+	}
+
+	// Explain poor recognition due to unsuitable resolutions
+	res := r.tess.SourceResolution()
+	if res < ocrpdf.MinReliableResolution ||
+		res > ocrpdf.MaxReliableResolution {
+		logef("[P%d] Resolution of %d DPI is outside the reliable "+
+			"range of %d-%d DPI. Try rescanning, or setting the "+
+			"correct resolution with --assume-dpi.\n", pageno, res,
+			ocrpdf.MinReliableResolution,
+			ocrpdf.MaxReliableResolution)
+	}
+
+	if *printText || c.textOut != nil {
+		recognised.text, err = r.tess.Text()
+		if err != nil {
+			logef("Couldn't recognise text: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if c.hocr != nil {
+		recognised.hocr, err = r.tess.HOCRText(pageno - 1)
+		if err != nil {
+			logef("Couldn't write hOCR for page %d: %s\n", pageno, err)
+			os.Exit(1)
+		}
+	}
+
+	if c.alto != nil {
+		recognised.alto, err = r.tess.ALTOText(pageno - 1)
+		if err != nil {
+			logef("Couldn't write ALTO for page %d: %s\n", pageno, err)
+			os.Exit(1)
+		}
+	}
+
+	if len(c.regions) > 0 {
+		logvf("[P%d] Recognising %d regions...\n", pageno, len(c.regions))
+		recognised.regionWords, err = r.tess.RegionWords(c.regions)
+		if err != nil {
+			logef("Couldn't recognise regions: %s\n", err)
+			os.Exit(1)
+		}
+	}
+
+	return recognised
+}
+
+// rotatePage rotates each of the images of the given page clockwise by the
+// given number of quarter turns, returning the rotated page and the images
+// it replaced, which are no longer needed. Images shared by the page (such
+// as an original that wasn't processed) are only rotated once.
+func rotatePage(page preparedPage, rotations int) (preparedPage,
+	[]*ocrpdf.Image) {
+	rotated := make(map[*ocrpdf.Image]*ocrpdf.Image, 3)
+	var replaced []*ocrpdf.Image
+	rotate := func(img *ocrpdf.Image) *ocrpdf.Image {
+		if result, ok := rotated[img]; ok {
+			return result
+		}
+		result := img.RotateOrth(rotations)
+		rotated[img] = result
+		if result != img {
+			replaced = append(replaced, img)
+		}
+		return result
+	}
+	page.original = rotate(page.original)
+	page.image = rotate(page.image)
+	page.ocrImage = rotate(page.ocrImage)
+	return page, replaced
+}
+
+// processPages prepares and recognises the given pages in the background,
+// with a worker for each job, sending each page to the returned
+// channel in order, until done is closed. The pages are numbered following
+// the last page processed.
+func (c *converter) processPages(doc *ocrpdf.Document, sources []pageSource,
+	done <-chan struct{}) <-chan recognisedPage {
+	type job struct {
+		src    pageSource
+		pageno int
+		result chan<- recognisedPage
+	}
+
+	// Results are queued in page order, so are collected in that order
+	// regardless of which worker finishes first
+	jobs := make(chan job)
+	queue := make(chan chan recognisedPage, c.jobs)
+	for i := 0; i < c.jobs; i++ {
+		go func() {
+			for j := range jobs {
+				page := c.prepare(doc, j.src, j.pageno)
+				j.result <- c.recognise(page, j.pageno)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		defer close(queue)
+		pageno := c.pageno
+		for _, src := range sources {
+			pageno++
+			result := make(chan recognisedPage, 1)
+			select {
+			case queue <- result:
+			case <-done:
+				return
+			}
+			select {
+			case jobs <- job{src, pageno, result}:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	processed := make(chan recognisedPage)
+	go func() {
+		defer close(processed)
+		for result := range queue {
+			var page recognisedPage
+			select {
+			case page = <-result:
+			case <-done:
+				return
+			}
+			select {
+			case processed <- page:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return processed
+}
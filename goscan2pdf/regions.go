@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// pageRegions holds the text recognised in each region of a page.
+type pageRegions struct {
+	Page    int               `json:"page"`
+	Regions map[string]string `json:"regions"`
+}
+
+// readRegions reads the list of regions from the named file.
+func readRegions(fn string) ([]ocrpdf.Region, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ocrpdf.ReadRegions(f)
+}
+
+// newPageRegions joins the words found in each region into a single string.
+func newPageRegions(page int, words map[string][]ocrpdf.Word) pageRegions {
+	regions := make(map[string]string, len(words))
+	for name, ws := range words {
+		texts := make([]string, len(ws))
+		for i, w := range ws {
+			texts[i] = w.Text
+		}
+		regions[name] = strings.Join(texts, " ")
+	}
+	return pageRegions{Page: page, Regions: regions}
+}
+
+// writeRegions writes the region text of each page to the named file as
+// JSON, or to stdout if no filename is given.
+func writeRegions(fn string, pages []pageRegions) error {
+	out := os.Stdout
+	if fn != "" {
+		f, err := os.Create(fn)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(pages)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// pageSource identifies the image used for a page; one of possibly several
+// images within a multi-page file.
+type pageSource struct {
+	filename string
+	file     int
+	index    int
+	count    int
+}
+
+// name returns a name that uniquely identifies the source image.
+func (s pageSource) name() string {
+	if s.count == 1 {
+		return s.filename
+	}
+	return fmt.Sprintf("%s#%d", s.filename, s.index+1)
+}
+
+// expandGlobs replaces any of the given filenames that are glob patterns
+// (such as "*.png") with the files they match, in sorted order, for the
+// benefit of shells that don't expand them. Filenames that exist are never
+// treated as patterns. Returns an error if a pattern matches no files.
+func expandGlobs(filenames []string) ([]string, error) {
+	var expanded []string
+	for _, fn := range filenames {
+		if !strings.ContainsAny(fn, "*?[") {
+			expanded = append(expanded, fn)
+			continue
+		}
+		if _, err := os.Stat(fn); err == nil {
+			expanded = append(expanded, fn)
+			continue
+		}
+
+		matches, err := filepath.Glob(fn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %s", fn, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("'%s' matches no files", fn)
+		}
+		sort.Strings(matches)
+		expanded = append(expanded, matches...)
+	}
+	return expanded, nil
+}
+
+// expandSources returns a source for each image in each of the given files.
+func expandSources(filenames []string) ([]pageSource, error) {
+	var sources []pageSource
+	for file, fn := range filenames {
+		n, err := ocrpdf.ImageCount(fn)
+		if err != nil {
+			return nil, err
+		}
+		for index := 0; index < n; index++ {
+			sources = append(sources, pageSource{fn, file, index, n})
+		}
+	}
+	return sources, nil
+}
+
+// addPageBookmarks adds a bookmark for the file itself if the current page is
+// the file's first page, and if grouping by file, a bookmark for the page
+// nested under it.
+func addPageBookmarks(doc *ocrpdf.Document, src pageSource) {
+	if !*groupByFile && !*bookmarkPerFile {
+		return
+	}
+	if src.index == 0 {
+		title := filepath.Base(src.filename)
+		if *docStripMetadata {
+			// Don't reveal file names
+			title = fmt.Sprintf("File %d", src.file+1)
+		}
+		doc.AddBookmark(title, 0)
+	}
+	if *groupByFile {
+		doc.AddBookmark(fmt.Sprintf("Page %d", src.index+1), 1)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// textWriter writes the plain text of each page to a single file, with the
+// pages separated by form feeds, as per pdftotext.
+type textWriter struct {
+	f     *os.File
+	pages int
+}
+
+// newTextWriter creates the named file.
+func newTextWriter(fn string) (*textWriter, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+	return &textWriter{f: f}, nil
+}
+
+// WritePage writes the text of a page, as returned by Tess.Text.
+func (t *textWriter) WritePage(text string) error {
+	if t.pages > 0 {
+		if _, err := io.WriteString(t.f, "\f"); err != nil {
+			return err
+		}
+	}
+	t.pages++
+	_, err := io.WriteString(t.f, text)
+	return err
+}
+
+// Close closes the file.
+func (t *textWriter) Close() error {
+	return t.f.Close()
+}
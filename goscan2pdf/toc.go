@@ -0,0 +1,25 @@
+package main
+
+import "github.com/johnsto/ocrpdf"
+
+// pageHeadings returns contents entries for the likely headings amongst the
+// words of the given page, if building a table of contents.
+func pageHeadings(words []ocrpdf.Word, page int) []ocrpdf.ContentsEntry {
+	if !*docTOC {
+		return nil
+	}
+	var entries []ocrpdf.ContentsEntry
+	for _, heading := range ocrpdf.Headings(words, *docTOCMinSize) {
+		entries = append(entries, ocrpdf.ContentsEntry{Title: heading, Page: page})
+	}
+	return entries
+}
+
+// addContents adds a table of contents page listing the given entries, if
+// there are any.
+func addContents(doc *ocrpdf.Document, entries []ocrpdf.ContentsEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	doc.AddContentsPage("Contents", entries)
+}
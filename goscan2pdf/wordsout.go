@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/johnsto/ocrpdf"
+)
+
+// tsvHeader names the columns written by wordsWriter in TSV format.
+var tsvHeader = []string{
+	"page", "source", "text", "left", "top", "width", "height", "confidence",
+}
+
+// wordsWriter writes the words of every page to a single file, either as a
+// JSON array of pages, or as TSV (if the file name ends in .tsv) with one row
+// per word.
+type wordsWriter struct {
+	f     *os.File
+	tsv   *csv.Writer
+	pages int
+}
+
+// newWordsWriter creates the named file, choosing the format from its
+// extension, and writes the start of the output to it.
+func newWordsWriter(fn string) (*wordsWriter, error) {
+	f, err := os.Create(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	ww := &wordsWriter{f: f}
+	if strings.EqualFold(filepath.Ext(fn), ".tsv") {
+		ww.tsv = csv.NewWriter(f)
+		ww.tsv.Comma = '\t'
+		err = ww.tsv.Write(tsvHeader)
+	} else {
+		_, err = io.WriteString(f, "[")
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return ww, nil
+}
+
+// WritePage writes the words of a page.
+func (ww *wordsWriter) WritePage(page pageWords) error {
+	if ww.tsv == nil {
+		return ww.writePageJSON(page)
+	}
+
+	p := strconv.Itoa(page.Page)
+	for _, word := range page.Words {
+		err := ww.tsv.Write([]string{
+			p,
+			page.Source,
+			word.Text,
+			strconv.Itoa(word.Left),
+			strconv.Itoa(word.Top),
+			strconv.Itoa(word.Width),
+			strconv.Itoa(word.Height),
+			strconv.FormatFloat(float64(word.Confidence), 'f', 2, 32),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writePageJSON writes the words of a page as an element of the JSON array.
+func (ww *wordsWriter) writePageJSON(page pageWords) error {
+	if page.Words == nil {
+		page.Words = []ocrpdf.Word{}
+	}
+
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	sep := "\n"
+	if ww.pages > 0 {
+		sep = ",\n"
+	}
+	if _, err := io.WriteString(ww.f, sep); err != nil {
+		return err
+	}
+	if _, err := ww.f.Write(data); err != nil {
+		return err
+	}
+	ww.pages++
+	return nil
+}
+
+// Close writes the end of the output and closes the file.
+func (ww *wordsWriter) Close() error {
+	var err error
+	if ww.tsv != nil {
+		ww.tsv.Flush()
+		err = ww.tsv.Error()
+	} else {
+		_, err = io.WriteString(ww.f, "\n]\n")
+	}
+	if err != nil {
+		ww.f.Close()
+		return err
+	}
+	return ww.f.Close()
+}
@@ -4,11 +4,16 @@ package ocrpdf
 
 // #cgo LDFLAGS: -llept
 // #include "leptonica/allheaders.h"
+// #include <stdio.h>
 // #include <stdlib.h>
 import "C"
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
 	"runtime"
 	"unsafe"
 )
@@ -17,58 +22,683 @@ const DefaultJPEGCompression int = 75
 
 var JPEGCompression int = DefaultJPEGCompression
 
+// DefaultMaxIndexedColors is the default number of distinct colours at or
+// below which the "smart" format stores an image as an indexed-colour PNG.
+const DefaultMaxIndexedColors int = 16
+
+var MaxIndexedColors int = DefaultMaxIndexedColors
+
+// DefaultPhotoMidtones is the default fraction of mid-tone pixels above which
+// IsPhoto considers an image to be a photograph.
+const DefaultPhotoMidtones float64 = 0.35
+
+var PhotoMidtones float64 = DefaultPhotoMidtones
+
+// DefaultDeskewThreshold is the default skew angle, in degrees, below which
+// Deskew leaves an image unchanged.
+const DefaultDeskewThreshold float32 = 0.1
+
+// DefaultSharpenRadius and DefaultSharpenFraction are the default settings
+// of Sharpen, which suit most blurry scans and photos of documents.
+const (
+	DefaultSharpenRadius           = 1
+	DefaultSharpenFraction float32 = 0.5
+)
+
+// DefaultBackgroundTileSize is the default size, in pixels, of the tiles in
+// which NormalizeBackground measures the background.
+const DefaultBackgroundTileSize = 10
+
+// normalizedBackground is the value NormalizeBackground brings the background
+// to, which is a little below white so that light text isn't clipped.
+const normalizedBackground = 230
+
+// DefaultBlankThreshold is the fraction of content pixels below which
+// IsBlank considers an image blank by default. This is low enough that a
+// page with just a few faint pencilled words isn't considered blank.
+const DefaultBlankThreshold float32 = 0.0005
+
+// blankContrast is how many grey levels darker than the paper a pixel must be
+// for IsBlank to consider it content, which is enough to ignore the texture
+// of the paper and faint bleed-through, but not faint pencil.
+const blankContrast = 48
+
+// minSkewConfidence is the minimum confidence in the skew angle found (as
+// per pixFindSkew) for Deskew to correct it.
+const minSkewConfidence = 3.0
+
+// invertedForeground is the fraction of foreground (dark) pixels above which
+// IsInverted considers an image to be a negative.
+const invertedForeground = 0.5
+
 // NewImageFromFile creates and returns a new image loaded from the given
-// file path.
+// file path. If a JPEG file is too corrupt to be read normally, a more
+// forgiving decoder is tried instead, and the image marked as Recovered.
+// Files that can't be read, including those in formats Leptonica doesn't
+// support, return an error. Images in formats that can't be embedded as they
+// are (see Reader) are re-encoded, rather than causing an error.
 func NewImageFromFile(filename string) (*Image, error) {
 	cFilename := C.CString(filename)
 	defer C.free(unsafe.Pointer(cFilename))
 
+	// Prefer the format of the content, as the extension may be missing or
+	// wrong, e.g. for files saved by scanners with generic names
+	var format C.l_int32
+	if C.findFileFormat(cFilename, &format) != 0 || format == C.IFF_UNKNOWN {
+		format = C.getImpliedFileFormat(cFilename)
+	}
+
 	// create new PIX
 	cPIX := C.pixRead(cFilename)
+	recovered := false
+	if cPIX == nil && isJPEGFile(cFilename) {
+		cPIX, _ = recoverJPEG(filename)
+		recovered = cPIX != nil
+	}
 	if cPIX == nil {
+		if name, ok := optionalFormats[format]; ok {
+			return nil, fmt.Errorf("could not read %s image from '%s' "+
+				"(Leptonica may have been built without %s support)",
+				name, filename, name)
+		}
 		return nil, fmt.Errorf("could not read image from '%s'", filename)
 	}
 
+	img := newImage(cPIX, format)
+	img.recovered = recovered
+
+	return img, nil
+}
+
+// NewImageFromReader creates and returns a new image from the encoded image
+// data (in any format that Leptonica supports) read from r, such as the body
+// of an HTTP request.
+func NewImageFromReader(r io.Reader) (*Image, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("could not read image from empty data")
+	}
+
+	cData := (*C.l_uint8)(unsafe.Pointer(&data[0]))
+	var format C.l_int32
+	C.findFileFormatBuffer(cData, &format)
+
+	cPIX := C.pixReadMem(cData, C.size_t(len(data)))
+	if cPIX == nil {
+		if name, ok := optionalFormats[format]; ok {
+			return nil, fmt.Errorf("could not read %s image from data "+
+				"(Leptonica may have been built without %s support)",
+				name, name)
+		}
+		return nil, fmt.Errorf("could not read image from data")
+	}
+
+	return newImage(cPIX, format), nil
+}
+
+// optionalFormats names the formats that Leptonica can only read if it was
+// built with the corresponding image library, which is often not the case.
+var optionalFormats = map[C.l_int32]string{
+	C.IFF_WEBP: "WebP",
+	C.IFF_JP2:  "JPEG 2000",
+	C.IFF_GIF:  "GIF",
+}
+
+// isJPEGFile returns true if the named file appears to be a JPEG, based on
+// either its content or its extension.
+func isJPEGFile(cFilename *C.char) bool {
+	var format C.l_int32
+	if C.findFileFormat(cFilename, &format) == 0 && format == C.IFF_JFIF_JPEG {
+		return true
+	}
+	return C.getImpliedFileFormat(cFilename) == C.IFF_JFIF_JPEG
+}
+
+// isTIFF returns true if the given Leptonica file format is a TIFF variant.
+func isTIFF(format C.l_int32) bool {
+	return format >= C.IFF_TIFF && format <= C.IFF_TIFF_ZIP
+}
+
+// ImageCount returns the number of images (pages) in the given file.
+// Multi-page TIFFs may contain many images, whilst all other formats contain
+// just one.
+func ImageCount(filename string) (int, error) {
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	var format C.l_int32
+	if C.findFileFormat(cFilename, &format) != 0 {
+		return 0, fmt.Errorf("could not determine format of '%s'", filename)
+	}
+	if !isTIFF(format) {
+		return 1, nil
+	}
+
+	fp := C.fopenReadStream(cFilename)
+	if fp == nil {
+		return 0, fmt.Errorf("could not open '%s'", filename)
+	}
+	defer C.fclose(fp)
+
+	var n C.l_int32
+	if C.tiffGetCount(fp, &n) != 0 {
+		return 0, fmt.Errorf("could not count images in '%s'", filename)
+	}
+	return int(n), nil
+}
+
+// NewImageFromFileIndex creates and returns a new image from the given
+// (zero-based) index of a multi-page file. See ImageCount.
+func NewImageFromFileIndex(filename string, index int) (*Image, error) {
+	cFilename := C.CString(filename)
+	defer C.free(unsafe.Pointer(cFilename))
+
+	var format C.l_int32
+	if C.findFileFormat(cFilename, &format) != 0 || !isTIFF(format) {
+		if index != 0 {
+			return nil, fmt.Errorf("could not read image %d from '%s'",
+				index, filename)
+		}
+		return NewImageFromFile(filename)
+	}
+
+	cPIX := C.pixReadTiff(cFilename, C.l_int32(index))
+	if cPIX == nil {
+		return nil, fmt.Errorf("could not read image %d from '%s'",
+			index, filename)
+	}
+
+	return newImage(cPIX, format), nil
+}
+
+// NewImageFromPIX creates an image from a Leptonica PIX created outside of
+// this package, such as by go.leptonica or other cgo bindings. The image
+// takes its own reference to the PIX (using pixClone) and only releases that
+// reference when finalized, so the caller remains responsible for destroying
+// its own reference as usual. The pixel data itself is shared, but is never
+// modified by this package. Returns nil if pix is nil.
+func NewImageFromPIX(pix unsafe.Pointer) *Image {
+	if pix == nil {
+		return nil
+	}
+
+	cPIX := C.pixClone((*C.PIX)(pix))
+	return newImage(cPIX, C.pixGetInputFormat(cPIX))
+}
+
+// newImage returns an image that takes ownership of the given PIX, destroying
+// it when the image is finalized.
+func newImage(cPIX *C.PIX, format C.l_int32) *Image {
 	img := &Image{
 		cPIX:      cPIX,
-		pixFormat: C.getImpliedFileFormat(cFilename),
+		pixFormat: format,
 	}
 
 	runtime.SetFinalizer(img, (*Image).delete)
 
-	return img, nil
+	return img
 }
 
+// Image is an image held in memory by Leptonica.
+//
+// Each *Image owns exactly one reference to its PIX, which is released once
+// the image is garbage collected. Operations that transform an image (such as
+// Scale or Adjust) never modify it, but return a new *Image owning a new PIX,
+// or the original image itself if no change was necessary. Either way, the
+// original remains valid and must be kept reachable for as long as its own
+// pixel data is needed, including by copies of the Image value (which share,
+// but don't own, the PIX).
 type Image struct {
 	cPIX      *C.PIX
 	buf       *bytes.Buffer
 	pixFormat C.l_int32
+	recovered bool
 }
 
 func (i *Image) delete() {
 	if i.cPIX != nil {
 		C.pixDestroy(&i.cPIX)
-		C.free(unsafe.Pointer(i.cPIX))
-		i.cPIX = nil
 	}
 }
 
+// Close releases the image's PIX immediately, rather than when the image is
+// garbage collected, which may be too late to avoid running out of memory
+// when processing many large images. The image must not be used afterwards,
+// but closing it again is harmless.
+func (i *Image) Close() error {
+	runtime.SetFinalizer(i, nil)
+	i.delete()
+	return nil
+}
+
 func (i *Image) CPIX() *C.PIX {
 	return i.cPIX
 }
 
+// Recovered returns true if the image was read from a corrupt file, in which
+// case it may be incomplete.
+func (i Image) Recovered() bool {
+	return i.recovered
+}
+
+// Clone returns a deep copy of the image, with its own copy of the pixel
+// data, which is independent of the original. This is rarely needed, as
+// transforms never modify the image they're applied to, but is useful for
+// passing images to code that modifies PIX data directly (see CPIX).
+func (i *Image) Clone() *Image {
+	cPIX := C.pixCopy(nil, i.cPIX)
+	if cPIX == nil {
+		return nil
+	}
+	img := newImage(cPIX, i.pixFormat)
+	img.recovered = i.recovered
+	return img
+}
+
+// ErrBiLevel is returned by AdjustContrast for bi-level (black and white)
+// images, which have no contrast to enhance. Such images can be cleaned up
+// with Despeckle instead.
+var ErrBiLevel = errors.New("can't enhance the contrast of a bi-level image")
+
 // Adjust improves the clarity and contrast of the image, generally reducing
-// scanning artifacts.
+// scanning artifacts. Bi-level images, and images that couldn't be adjusted,
+// are returned unchanged; use AdjustContrast to tell when this happens.
 func (i *Image) Adjust(threshold float32) *Image {
+	img, err := i.AdjustContrast(threshold)
+	if err != nil {
+		return i
+	}
+	return img
+}
+
+// AdjustContrast is Adjust, returning ErrBiLevel for bi-level images, or
+// another error if the image couldn't be adjusted.
+func (i *Image) AdjustContrast(threshold float32) (*Image, error) {
 	depth := C.pixGetDepth(i.cPIX)
 	if depth == 1 {
 		// Can't improve contrast on 1BPP images!
+		return nil, ErrBiLevel
+	}
+
+	cPIX := i.cPIX
+	if converted := convertUnusualDepth(cPIX); converted != nil {
+		cPIX = converted
+		defer C.pixDestroy(&converted)
+	}
+
+	result := C.pixContrastTRC(nil, cPIX, C.l_float32(threshold))
+	if result == nil {
+		return nil, fmt.Errorf("could not adjust contrast of %dbpp image",
+			depth)
+	}
+	return newImage(result, i.pixFormat), nil
+}
+
+// convertUnusualDepth returns a copy of the given PIX converted to 8bpp if it
+// has a depth (2, 4 or 16bpp) that most Leptonica operations and encoders
+// don't support, or nil if it doesn't need converting. Any colormap is kept.
+func convertUnusualDepth(cPIX *C.PIX) *C.PIX {
+	switch C.pixGetDepth(cPIX) {
+	case 2, 4, 16:
+		return C.pixConvertTo8(cPIX, 1)
+	}
+	return nil
+}
+
+// WhiteBalance neutralises any colour cast in the image, such as the yellow
+// of old paper or the blue of fluorescent lighting, by scaling each channel
+// such that the brightest tones (typically the paper) become white. Greyscale
+// and bi-level images are returned unchanged, as is the original image if
+// the white point could not be determined.
+func (i *Image) WhiteBalance() *Image {
+	if C.pixGetDepth(i.cPIX) != 32 {
+		return i
+	}
+
+	var r, g, b C.l_float32
+	if C.pixGetRankValueMaskedRGB(i.cPIX, nil, 0, 0, 2, 0.9,
+		&r, &g, &b) != 0 {
+		return i
+	}
+	if r <= 0 || g <= 0 || b <= 0 {
+		return i
+	}
+
+	result := C.pixMultConstantColor(i.cPIX, 255/r, 255/g, 255/b)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// NormalizeBackground flattens uneven lighting, such as the shadows and dark
+// corners of photographed pages, by measuring the background in square tiles
+// of tileSize pixels and scaling each region such that its background becomes
+// near white. This greatly improves the results of Adjust and Binarize on such
+// images, so should be done before them. Bi-level images are returned
+// unchanged, as is the original image if it couldn't be normalized.
+func (i *Image) NormalizeBackground(tileSize int) *Image {
+	if tileSize < 4 || C.pixGetDepth(i.cPIX) == 1 {
+		return i
+	}
+
+	cPIX := i.cPIX
+	if converted := convertUnusualDepth(cPIX); converted != nil {
+		cPIX = converted
+		defer C.pixDestroy(&converted)
+	}
+	if C.pixGetColormap(cPIX) != nil {
+		converted := C.pixRemoveColormap(cPIX, C.REMOVE_CMAP_BASED_ON_SRC)
+		if converted == nil {
+			return i
+		}
+		cPIX = converted
+		defer C.pixDestroy(&converted)
+	}
+
+	// Tiles with too few background (light) pixels are filled in from
+	// their neighbours
+	tile := C.l_int32(tileSize)
+	result := C.pixBackgroundNorm(cPIX, nil, nil, tile, tile, 60,
+		tile*tile/4, normalizedBackground, 2, 1)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Dither converts the image to bi-level (black and white) using
+// Floyd-Steinberg error diffusion, which preserves the tones of photographs
+// and other greyscale content much better than thresholding. Dithered images
+// compress to very small sizes, but are difficult to recognise text in, so
+// should only be used for storage. Bi-level images are returned unchanged.
+func (i *Image) Dither() *Image {
+	if C.pixGetDepth(i.cPIX) == 1 {
+		return i
+	}
+
+	gray := C.pixConvertTo8(i.cPIX, 0)
+	if gray == nil {
+		return i
+	}
+	defer C.pixDestroy(&gray)
+
+	result := C.pixDitherToBinary(gray)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Deskew returns a copy of the image rotated to correct any skew (such as
+// from a page being placed at a slight angle in a flatbed scanner) of at
+// least threshold degrees. Areas brought into view by the rotation are white.
+// The original image is returned if the skew is smaller than the threshold,
+// or the skew angle couldn't be determined.
+func (i *Image) Deskew(threshold float32) *Image {
+	angle, ok := i.SkewAngle(threshold)
+	if !ok {
 		return i
 	}
-	result := C.pixContrastTRC(i.cPIX, i.cPIX, C.l_float32(threshold))
-	return &Image{
-		cPIX: result,
+
+	w, h, _ := i.Dimensions()
+	radians := C.l_float32(angle * math.Pi / 180)
+	result := C.pixRotate(i.cPIX, radians, C.L_ROTATE_AREA_MAP,
+		C.L_BRING_IN_WHITE, C.l_int32(w), C.l_int32(h))
+	if result == nil {
+		return i
 	}
+	return newImage(result, i.pixFormat)
+}
+
+// SkewAngle returns the angle, in degrees, by which Deskew would rotate the
+// image to correct its skew, and whether it would. This allows other images
+// of the same size, such as the image before processing, to be rotated by
+// the same angle (see Rotate), so they stay aligned with the deskewed image.
+func (i *Image) SkewAngle(threshold float32) (float64, bool) {
+	binary := i.cPIX
+	if C.pixGetDepth(binary) != 1 {
+		binary = C.pixConvertTo1(i.cPIX, 130)
+		if binary == nil {
+			return 0, false
+		}
+		defer C.pixDestroy(&binary)
+	}
+
+	var angle, conf C.l_float32
+	if C.pixFindSkew(binary, &angle, &conf) != 0 {
+		return 0, false
+	}
+	if conf < minSkewConfidence ||
+		math.Abs(float64(angle)) < float64(threshold) {
+		return 0, false
+	}
+	return float64(angle), true
+}
+
+// Binarize converts the image to bi-level (black and white) using Otsu's
+// method in tiles, adapting the threshold to local variations such as
+// shadows or uneven lighting. This gives a clean image for recognition of
+// text-heavy scans, which is also very small when stored (see ReaderPNG),
+// but loses any photographs or other shaded content. Bi-level images are
+// returned unchanged, as is the original image if it couldn't be converted.
+func (i *Image) Binarize() *Image {
+	if C.pixGetDepth(i.cPIX) == 1 {
+		return i
+	}
+
+	gray := C.pixConvertTo8(i.cPIX, 0)
+	if gray == nil {
+		return i
+	}
+	defer C.pixDestroy(&gray)
+
+	var result *C.PIX
+	if C.pixOtsuAdaptiveThreshold(gray, 300, 300, 1, 1, 0.1, nil,
+		&result) != 0 || result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Sharpen returns a copy of the image sharpened with an unsharp mask, which
+// can make the text of blurry scans and photos easier to recognise. radius
+// is the half-width of the blur used to find edges, in pixels, and fraction
+// (0-1) the amount by which edges are enhanced. Oversharpening creates halos
+// and exaggerates noise, which hinder recognition, so small values are best.
+// Bi-level images, and images that couldn't be sharpened, are returned
+// unchanged.
+func (i *Image) Sharpen(radius int, fraction float32) *Image {
+	if radius < 1 || fraction <= 0 || C.pixGetDepth(i.cPIX) == 1 {
+		return i
+	}
+
+	result := C.pixUnsharpMasking(i.cPIX, C.l_int32(radius),
+		C.l_float32(fraction))
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Despeckle returns a copy of the image with specks of noise (such as those
+// left by old photocopiers) removed, which would otherwise be misread as
+// punctuation. Specks are connected components smaller than size pixels in
+// both width and height, so thin strokes of text (which are long in at least
+// one direction) are preserved. Only bi-level images can be despeckled, so
+// other images are binarized first. If there are no specks, the (binarized)
+// image is returned, and if it couldn't be despeckled, the image itself.
+func (i *Image) Despeckle(size int) *Image {
+	if size <= 1 {
+		return i
+	}
+
+	// The binarized copy is closed unless it's returned
+	img := i.Binarize()
+	closeBinarized := func() {
+		if img != i {
+			img.Close()
+		}
+	}
+	if C.pixGetDepth(img.cPIX) != 1 {
+		closeBinarized()
+		return i
+	}
+
+	var changed C.l_int32
+	result := C.pixSelectBySize(img.cPIX, C.l_int32(size), C.l_int32(size),
+		8, C.L_SELECT_IF_EITHER, C.L_SELECT_IF_GTE, &changed)
+	if result == nil {
+		closeBinarized()
+		return i
+	}
+	if changed == 0 {
+		C.pixDestroy(&result)
+		return img
+	}
+	closeBinarized()
+	return newImage(result, i.pixFormat)
+}
+
+// Grayscale converts a colour image to greyscale, which is typically much
+// smaller when stored. Images that are already greyscale or bi-level are
+// returned unchanged, as is the original image if it couldn't be converted.
+func (i *Image) Grayscale() *Image {
+	var result *C.PIX
+	switch {
+	case C.pixGetColormap(i.cPIX) != nil:
+		result = C.pixRemoveColormap(i.cPIX, C.REMOVE_CMAP_TO_GRAYSCALE)
+	case C.pixGetDepth(i.cPIX) == 32:
+		result = C.pixConvertRGBToGray(i.cPIX, 0, 0, 0)
+	default:
+		return i
+	}
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Invert returns a negative of the image, such as to restore a scan of
+// white-on-black text to black-on-white.
+func (i *Image) Invert() *Image {
+	cPIX := i.cPIX
+	if C.pixGetColormap(cPIX) != nil {
+		cPIX = C.pixRemoveColormap(cPIX, C.REMOVE_CMAP_BASED_ON_SRC)
+		if cPIX == nil {
+			return i
+		}
+		defer C.pixDestroy(&cPIX)
+	}
+
+	result := C.pixInvert(nil, cPIX)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Crop returns a copy of the image clipped to the given rectangle, in
+// pixels. The rectangle is clamped to the bounds of the image, and if it
+// covers the whole image (or none of it), the image itself is returned.
+func (i *Image) Crop(x, y, w, h int32) *Image {
+	iw, ih, _ := i.Dimensions()
+	if x < 0 {
+		w, x = w+x, 0
+	}
+	if y < 0 {
+		h, y = h+y, 0
+	}
+	if x+w > iw {
+		w = iw - x
+	}
+	if y+h > ih {
+		h = ih - y
+	}
+	if w <= 0 || h <= 0 || (w == iw && h == ih) {
+		return i
+	}
+
+	box := C.boxCreate(C.l_int32(x), C.l_int32(y), C.l_int32(w), C.l_int32(h))
+	defer C.boxDestroy(&box)
+	result := C.pixClipRectangle(i.cPIX, box, nil)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// RemoveLines returns a copy of the image with long horizontal and/or
+// vertical lines removed, such as the rules and boxes of forms, which would
+// otherwise be misread as characters. Lines are found using morphological
+// openings on a bi-level copy of the image, and painted over in white.
+func (i *Image) RemoveLines(horizontal, vertical bool) *Image {
+	if !horizontal && !vertical {
+		return i
+	}
+
+	cPIX := i.cPIX
+	if C.pixGetColormap(cPIX) != nil {
+		cPIX = C.pixRemoveColormap(cPIX, C.REMOVE_CMAP_BASED_ON_SRC)
+		if cPIX == nil {
+			return i
+		}
+		defer C.pixDestroy(&cPIX)
+	}
+
+	binary := cPIX
+	if C.pixGetDepth(cPIX) != 1 {
+		binary = C.pixConvertTo1(cPIX, 128)
+		if binary == nil {
+			return i
+		}
+		defer C.pixDestroy(&binary)
+	}
+
+	// Find lines that are at least 51px long, then thicken them slightly to
+	// catch their fuzzy edges
+	var lines *C.PIX
+	findLines := func(sequence string) {
+		cSequence := C.CString(sequence)
+		defer C.free(unsafe.Pointer(cSequence))
+		found := C.pixMorphSequence(binary, cSequence, 0)
+		if found == nil {
+			return
+		}
+		if lines == nil {
+			lines = found
+			return
+		}
+		C.pixOr(lines, lines, found)
+		C.pixDestroy(&found)
+	}
+	if horizontal {
+		findLines("o51.1 + d3.3")
+	}
+	if vertical {
+		findLines("o1.51 + d3.3")
+	}
+	if lines == nil {
+		return i
+	}
+	defer C.pixDestroy(&lines)
+
+	var result *C.PIX
+	if C.pixGetDepth(cPIX) == 1 {
+		result = C.pixSubtract(nil, cPIX, lines)
+	} else {
+		result = C.pixCopy(nil, cPIX)
+		if result != nil {
+			C.pixSetMasked(result, lines, 0xffffffff)
+		}
+	}
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
 }
 
 // Dimensions calculates the width, height and colour depth of the image.
@@ -88,30 +718,302 @@ func (i Image) Dimensions() (int32, int32, int32) {
 	return w, h, d
 }
 
+// GetResolution returns the resolution of the image, in pixels per inch, as
+// recorded in the file it was read from. Either is 0 if unknown.
+func (i Image) GetResolution() (xres, yres int32) {
+	var cXRes, cYRes C.l_int32
+	C.pixGetResolution(i.cPIX, &cXRes, &cYRes)
+	return int32(cXRes), int32(cYRes)
+}
+
+// SetResolution sets the resolution of the image, in pixels per inch, which
+// is recorded in the metadata of encoded JPEG and PNG image data.
+func (i Image) SetResolution(xres, yres int32) {
+	C.pixSetResolution(i.cPIX, C.l_int32(xres), C.l_int32(yres))
+}
+
 // Scale resizes the image to the specified dimensions.
 func (i *Image) Scale(w, h int32) *Image {
 	result := C.pixScaleToSize(i.cPIX, C.l_int32(w), C.l_int32(h))
-	return &Image{
-		cPIX: result,
+	if result == nil {
+		return i
 	}
+	return newImage(result, i.pixFormat)
 }
 
-// ScaleDown scales down the image to the specified dimensions, returning
-// the original image if it is already smaller (in terms of pixel count)
+// ScaleDown scales down the image, preserving its aspect ratio, to fit
+// within the specified dimensions, returning the original image if it
+// already fits.
 func (i *Image) ScaleDown(w, h int32) *Image {
+	if sw, sh, ok := i.fitWithin(w, h); ok {
+		return i.Scale(sw, sh)
+	}
+	// No scaling necessary
+	return i
+}
+
+// fitWithin returns the dimensions of the image scaled down, preserving its
+// aspect ratio, to fit within the given dimensions, and true if it needs
+// scaling to do so.
+func (i *Image) fitWithin(w, h int32) (int32, int32, bool) {
 	cw, ch, _ := i.Dimensions()
-	if int64(w)*int64(h) < int64(cw)*int64(ch) {
-		return i.Scale(w, h)
+	if cw <= w && ch <= h {
+		return cw, ch, false
+	}
+
+	// Scale by whichever dimension overflows the most
+	factor := math.Min(float64(w)/float64(cw), float64(h)/float64(ch))
+	sw := int32(math.Max(1, math.Floor(float64(cw)*factor+0.5)))
+	sh := int32(math.Max(1, math.Floor(float64(ch)*factor+0.5)))
+	return sw, sh, true
+}
+
+// ScaleSmooth resizes the image to the specified dimensions, smoothing
+// (antialiasing) the result. Bi-level images are scaled to greyscale. This
+// looks much better than Scale when reducing the size of an image, but should
+// not be used for enlarging it.
+func (i *Image) ScaleSmooth(w, h int32) *Image {
+	cw, ch, d := i.Dimensions()
+	sx := C.l_float32(float64(w) / float64(cw))
+	sy := C.l_float32(float64(h) / float64(ch))
+
+	// Bi-level images are converted to greyscale first, so they're smoothed
+	// like any other image, with separate factors for each axis
+	cPIX := i.cPIX
+	if d == 1 {
+		cPIX = C.pixConvertTo8(i.cPIX, 0)
+		if cPIX == nil {
+			return i
+		}
+		defer C.pixDestroy(&cPIX)
+	}
+	result := C.pixScaleSmooth(cPIX, sx, sy)
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// ScaleDownSmooth is like ScaleDown, but smooths the result (see
+// ScaleSmooth).
+func (i *Image) ScaleDownSmooth(w, h int32) *Image {
+	if sw, sh, ok := i.fitWithin(w, h); ok {
+		return i.ScaleSmooth(sw, sh)
 	}
 	// No scaling necessary
 	return i
 }
 
+// RotateOrth rotates the image clockwise by the given number of quarter
+// turns (90 degrees).
+func (i *Image) RotateOrth(quads int) *Image {
+	quads = (quads%4 + 4) % 4
+	if quads == 0 {
+		return i
+	}
+	result := C.pixRotateOrth(i.cPIX, C.l_int32(quads))
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// Rotate rotates the image clockwise by the given number of degrees. Right
+// angles use the faster RotateOrth, whilst other angles enlarge the image
+// to fit all of the rotated image, filling the corners with white.
+// Bi-level images are rotated by shearing, which keeps them bi-level, and
+// other images are interpolated. The original image is returned if it
+// couldn't be rotated.
+func (i *Image) Rotate(degrees float64) *Image {
+	if math.Mod(degrees, 90) == 0 {
+		return i.RotateOrth(int(degrees / 90))
+	}
+
+	rotation := C.l_int32(C.L_ROTATE_AREA_MAP)
+	if C.pixGetDepth(i.cPIX) == 1 {
+		rotation = C.L_ROTATE_SHEAR
+	}
+
+	w, h, _ := i.Dimensions()
+	radians := C.l_float32(degrees * math.Pi / 180)
+	result := C.pixRotate(i.cPIX, radians, rotation, C.L_BRING_IN_WHITE,
+		C.l_int32(w), C.l_int32(h))
+	if result == nil {
+		return i
+	}
+	return newImage(result, i.pixFormat)
+}
+
+// NumColors returns the number of distinct colours in the image, or 0 if the
+// image contains more than 256 colours.
+func (i Image) NumColors() int {
+	var n C.l_int32
+	if C.pixNumColors(i.cPIX, 1, &n) != 0 {
+		return 0
+	}
+	return int(n)
+}
+
+// Midtones returns the fraction of pixels in the image whose grey level falls
+// within the mid-tone range (64-191). Documents consist mostly of light paper
+// and dark text, so have few mid-tones, whereas photographs have many.
+func (i Image) Midtones() float64 {
+	return i.grayFraction(64, 192)
+}
+
+// Foreground returns the fraction of pixels in the image that are dark (in
+// the lower half of the grey levels), as text and other foreground content
+// usually are.
+func (i Image) Foreground() float64 {
+	return i.grayFraction(0, 128)
+}
+
+// IsInverted returns true if the image appears to be a negative (such as
+// white text on a black background), i.e. most of the image is foreground.
+// This may also be true of legitimately dark images.
+func (i Image) IsInverted() bool {
+	return i.Foreground() > invertedForeground
+}
+
+// PerceptualHash returns an average hash of the image, which changes little
+// with scaling, compression, or small changes in brightness or contrast, so
+// can be used to find near-duplicate images. The image is reduced to 8x8
+// grey pixels, and each bit set (from the most significant, in reading
+// order) if the pixel is brighter than average. Images whose hashes differ by
+// only a few bits (see HashDistance) are likely duplicates. Returns 0 if the
+// hash couldn't be computed.
+func (i Image) PerceptualHash() uint64 {
+	gray := C.pixConvertTo8(i.cPIX, 0)
+	if gray == nil {
+		return 0
+	}
+	defer C.pixDestroy(&gray)
+
+	small := C.pixScaleToSize(gray, 8, 8)
+	if small == nil {
+		return 0
+	}
+	defer C.pixDestroy(&small)
+
+	var levels [64]uint32
+	var total uint32
+	for n := range levels {
+		var v C.l_uint32
+		C.pixGetPixel(small, C.l_int32(n%8), C.l_int32(n/8), &v)
+		levels[n] = uint32(v)
+		total += uint32(v)
+	}
+
+	var hash uint64
+	for _, level := range levels {
+		hash <<= 1
+		if level*64 > total {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// HashDistance returns the number of bits that differ between two hashes
+// returned by PerceptualHash, from 0 (identical) to 64.
+func HashDistance(a, b uint64) int {
+	n := 0
+	for x := a ^ b; x != 0; x &= x - 1 {
+		n++
+	}
+	return n
+}
+
+// IsBlank returns true if the image appears to be a blank page (such as the
+// back of a single-sided page scanned in duplex), i.e. less than threshold
+// (see DefaultBlankThreshold) of its pixels are content. Content is anything
+// noticeably darker than the paper, whose shade is taken to be the median
+// grey level of the image, so off-white and greyish paper are handled too.
+// Dark borders around scans count as content, so should be cropped first.
+func (i Image) IsBlank(threshold float32) bool {
+	hist := i.grayHistogram()
+	if hist == nil {
+		return false
+	}
+
+	var total float64
+	for _, v := range hist {
+		total += v
+	}
+	paper, below := 0, 0.0
+	for level, v := range hist {
+		if below+v > total/2 {
+			paper = level
+			break
+		}
+		below += v
+	}
+
+	var content float64
+	for level := 0; level < paper-blankContrast; level++ {
+		content += hist[level]
+	}
+	return content < float64(threshold)*total
+}
+
+// grayFraction returns the fraction of pixels in the image whose grey level
+// is in the range [from, to).
+func (i Image) grayFraction(from, to int) float64 {
+	var total, in float64
+	for level, v := range i.grayHistogram() {
+		total += v
+		if level >= from && level < to {
+			in += v
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return in / total
+}
+
+// grayHistogram returns the number of pixels at each grey level (0-255) in a
+// sample of the image, or nil if it couldn't be computed.
+func (i Image) grayHistogram() []float64 {
+	cPIX := C.pixConvertTo8(i.cPIX, 0)
+	if cPIX == nil {
+		return nil
+	}
+	defer C.pixDestroy(&cPIX)
+
+	// Sample every 4th pixel, which is plenty for a histogram
+	na := C.pixGetGrayHistogram(cPIX, 4)
+	if na == nil {
+		return nil
+	}
+	defer C.numaDestroy(&na)
+
+	hist := make([]float64, int(C.numaGetCount(na)))
+	for level := range hist {
+		var v C.l_float32
+		C.numaGetFValue(na, C.l_int32(level), &v)
+		hist[level] = float64(v)
+	}
+	return hist
+}
+
+// IsPhoto returns true if the image appears to be a photograph rather than a
+// document, based on the fraction of mid-tones it contains (see Midtones and
+// PhotoMidtones). Bi-level images are never considered to be photographs.
+func (i Image) IsPhoto() bool {
+	if C.pixGetDepth(i.cPIX) == 1 {
+		return false
+	}
+	return i.Midtones() > PhotoMidtones
+}
+
 // FormatString returns the image format as a string, e.g. 'jpg'
 func (i Image) FormatString() string {
 	return map[C.l_int32]string{
 		C.IFF_JFIF_JPEG: "jpg",
 		C.IFF_PNG:       "png",
+		C.IFF_WEBP:      "webp",
+		C.IFF_JP2:       "jp2",
 	}[i.pixFormat]
 }
 
@@ -132,7 +1034,15 @@ func (i Image) ReaderJPEG(quality int, progressive bool) (*bytes.Buffer, error)
 		p = C.l_int32(1)
 	}
 
-	C.pixWriteMemJpeg(&data, &length, i.cPIX, q, p)
+	cPIX := i.cPIX
+	if converted := convertUnusualDepth(cPIX); converted != nil {
+		cPIX = converted
+		defer C.pixDestroy(&converted)
+	}
+
+	if C.pixWriteMemJpeg(&data, &length, cPIX, q, p) != 0 {
+		return nil, fmt.Errorf("could not encode image as JPEG")
+	}
 	defer C.free(unsafe.Pointer(data))
 	buf := C.GoBytes(unsafe.Pointer(data), C.int(size*int(length)))
 
@@ -153,14 +1063,88 @@ func (i Image) ReaderPNG(gamma float32) (*bytes.Buffer, error) {
 	return bytes.NewBuffer(buf), nil
 }
 
-// Reader returns an io.Reader for the image data. If format is not specified,
-// the reader will produce image data in the original image format. Otherwise,
-// `format` must be either "jpeg" or "png"
+// EncodeOptions holds the settings used to encode images as JPEGs.
+type EncodeOptions struct {
+	// JPEGQuality is the quality (1-100) of JPEGs, or if 0, JPEGCompression
+	JPEGQuality int
+	// ProgressiveJPEG enables progressive encoding of JPEGs
+	ProgressiveJPEG bool
+}
+
+// jpegQuality returns the JPEG quality to encode images with.
+func (o EncodeOptions) jpegQuality() int {
+	if o.JPEGQuality == 0 {
+		return JPEGCompression
+	}
+	return o.JPEGQuality
+}
+
+// ReaderSmart returns an io.Reader for the image data, choosing a format
+// based on the image content. Bi-level images, colormapped images and those
+// with no more than MaxIndexedColors colours are stored as (indexed-colour)
+// PNGs, whilst all other images are stored as JPEGs.
+func (i Image) ReaderSmart() (*bytes.Buffer, string, error) {
+	return i.readerSmart(EncodeOptions{})
+}
+
+// readerSmart is ReaderSmart, encoding any JPEG with the given options.
+func (i Image) readerSmart(opts EncodeOptions) (*bytes.Buffer, string,
+	error) {
+	depth := C.pixGetDepth(i.cPIX)
+	if depth == 1 || C.pixGetColormap(i.cPIX) != nil {
+		buf, err := i.ReaderPNG(0.0)
+		return buf, "png", err
+	}
+
+	if n := i.NumColors(); n > 0 && n <= MaxIndexedColors {
+		if depth != 32 {
+			// Greyscale with few levels compresses well as-is
+			buf, err := i.ReaderPNG(0.0)
+			return buf, "png", err
+		}
+		cPIX := C.pixConvertRGBToColormap(i.cPIX, 0)
+		if cPIX != nil {
+			indexed := &Image{cPIX: cPIX}
+			defer indexed.delete()
+			buf, err := indexed.ReaderPNG(0.0)
+			return buf, "png", err
+		}
+	}
+
+	buf, err := i.ReaderJPEG(opts.jpegQuality(), opts.ProgressiveJPEG)
+	return buf, "jpg", err
+}
+
+// Reader returns an io.Reader for the image data. If format is "auto" (or not
+// specified), the reader will produce image data in the original image
+// format, so JPEGs stay JPEGs and PNGs stay PNGs. The PDF library can't embed
+// WebP or JPEG 2000 images, which are typically lossy, so they become JPEGs.
+// Other formats (such as TIFF), and images that can't be stored as JPEG,
+// fall back to PNG.
+// Otherwise, `format` must be one of "jpeg", "png" or "smart" (see
+// ReaderSmart).
 func (i Image) Reader(format string) (*bytes.Buffer, string, error) {
+	return i.ReaderWithOptions(format, EncodeOptions{})
+}
+
+// ReaderWithOptions is Reader, encoding any JPEG with the given options.
+func (i Image) ReaderWithOptions(format string, opts EncodeOptions) (
+	*bytes.Buffer, string, error) {
 	pixFormat := i.pixFormat
 	switch format {
 	case "png":
 		pixFormat = C.IFF_PNG
+	case "smart":
+		return i.readerSmart(opts)
+	case "", "auto":
+		if pixFormat == C.IFF_WEBP || pixFormat == C.IFF_JP2 {
+			pixFormat = C.IFF_JFIF_JPEG
+		}
+		// Bi-level and colormapped images can't be stored as JPEG
+		if pixFormat != C.IFF_JFIF_JPEG || C.pixGetDepth(i.cPIX) == 1 ||
+			C.pixGetColormap(i.cPIX) != nil {
+			pixFormat = C.IFF_PNG
+		}
 	default:
 		pixFormat = C.IFF_JFIF_JPEG
 	}
@@ -170,7 +1154,7 @@ func (i Image) Reader(format string) (*bytes.Buffer, string, error) {
 		buf, err := i.ReaderPNG(0.0)
 		return buf, "png", err
 	case C.IFF_JFIF_JPEG:
-		buf, err := i.ReaderJPEG(JPEGCompression, false)
+		buf, err := i.ReaderJPEG(opts.jpegQuality(), opts.ProgressiveJPEG)
 		return buf, "jpg", err
 	default:
 		return nil, "", fmt.Errorf("unsupported image format %d [%s]",
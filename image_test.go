@@ -0,0 +1,339 @@
+package ocrpdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// readTestImage writes img to a PNG file in a temporary directory, and reads
+// it back, as goscan2pdf reads scans.
+func readTestImage(t *testing.T, img image.Image) *Image {
+	t.Helper()
+	fn := filepath.Join(t.TempDir(), "test.png")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = png.Encode(f, img)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	i, err := NewImageFromFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+// encodedPNG returns the image encoded as PNG, which changes if its pixels
+// do.
+func encodedPNG(t *testing.T, img *Image) []byte {
+	t.Helper()
+	buf, err := img.ReaderPNG(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// scanPattern returns a greyscale image of a dark block on white paper.
+func scanPattern(w, h int) *image.Gray {
+	gray := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.Gray{Y: 0xf0}
+			if x > w/4 && x < 3*w/4 && y > h/3 && y < 2*h/3 {
+				c.Y = 0x20
+			}
+			gray.SetGray(x, y, c)
+		}
+	}
+	return gray
+}
+
+func TestTransformChainOwnership(t *testing.T) {
+	src := readTestImage(t, scanPattern(64, 48))
+	want := encodedPNG(t, src)
+
+	// Every image in the chain owns its own PIX, so finalizing the
+	// intermediate and final images must neither free the source's PIX nor
+	// free any PIX twice
+	for n := 0; n < 20; n++ {
+		img := src.Adjust(0.5).Scale(32, 24).RotateOrth(1).Dither()
+		if w, h, _ := img.Dimensions(); w != 24 || h != 32 {
+			t.Fatalf("transformed image is %dx%d, expected 24x32", w, h)
+		}
+	}
+	for n := 0; n < 3; n++ {
+		runtime.GC()
+	}
+
+	if !bytes.Equal(encodedPNG(t, src), want) {
+		t.Error("transforms changed the image they were applied to")
+	}
+}
+
+// palettedGray returns a 64x48 image with a palette of the given number of
+// grey levels, which PNG stores, and Leptonica reads, at the smallest depth
+// that holds the palette: 2bpp for 4 levels, and 4bpp for 16.
+func palettedGray(levels int) *image.Paletted {
+	palette := make(color.Palette, levels)
+	for n := range palette {
+		palette[n] = color.Gray{Y: uint8(255 * n / (levels - 1))}
+	}
+	img := image.NewPaletted(image.Rect(0, 0, 64, 48), palette)
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			img.SetColorIndex(x, y, uint8((x/8+y/8)%levels))
+		}
+	}
+	return img
+}
+
+func TestLowDepths(t *testing.T) {
+	for _, test := range []struct {
+		levels int
+		depth  int32
+	}{
+		{4, 2},
+		{16, 4},
+	} {
+		t.Run(fmt.Sprintf("%dbpp", test.depth), func(t *testing.T) {
+			img := readTestImage(t, palettedGray(test.levels))
+			if _, _, d := img.Dimensions(); d != test.depth {
+				t.Fatalf("read image at %dbpp, expected %dbpp", d,
+					test.depth)
+			}
+
+			// pixContrastTRC doesn't support these depths, so Adjust must
+			// work on an 8bpp copy rather than give up
+			adjusted := img.Adjust(0.5)
+			if adjusted == img {
+				t.Error("image wasn't adjusted")
+			} else if _, _, d := adjusted.Dimensions(); d != 8 {
+				t.Errorf("adjusted image is %dbpp, expected 8bpp", d)
+			}
+
+			// Nor does the JPEG encoder, which used to produce no data
+			buf, err := img.ReaderJPEG(75, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := jpeg.Decode(buf)
+			if err != nil {
+				t.Fatalf("invalid JPEG: %s", err)
+			}
+			if b := decoded.Bounds(); b.Dx() != 64 || b.Dy() != 48 {
+				t.Errorf("JPEG is %dx%d, expected 64x48", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestCloneIndependent(t *testing.T) {
+	src := readTestImage(t, scanPattern(64, 48))
+	want := encodedPNG(t, src)
+
+	clone := src.Clone()
+	if clone == nil {
+		t.Fatal("Clone returned nil")
+	}
+	if clone.CPIX() == src.CPIX() {
+		t.Fatal("clone shares its source's PIX")
+	}
+	if !bytes.Equal(encodedPNG(t, clone), want) {
+		t.Error("clone differs from its source")
+	}
+
+	// The clone owns its copy, which outlives the source's PIX
+	src = nil
+	for n := 0; n < 3; n++ {
+		runtime.GC()
+	}
+	if !bytes.Equal(encodedPNG(t, clone), want) {
+		t.Error("clone changed once its source was finalized")
+	}
+}
+
+func TestAdjustRepeated(t *testing.T) {
+	var data bytes.Buffer
+	if err := png.Encode(&data, scanPattern(64, 48)); err != nil {
+		t.Fatal(err)
+	}
+	src, err := NewImageFromReader(&data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := encodedPNG(t, src)
+
+	// Adjust used to adjust its image in place, returning another Image
+	// sharing the same PIX, which would be freed twice once both images
+	// were finalized
+	img := src
+	for n := 0; n < 10; n++ {
+		adjusted := img.Adjust(0.5)
+		if adjusted.CPIX() == img.CPIX() {
+			t.Fatalf("Adjust %d returned an image sharing its PIX", n)
+		}
+		img = adjusted
+	}
+	for n := 0; n < 3; n++ {
+		runtime.GC()
+	}
+
+	if !bytes.Equal(encodedPNG(t, src), want) {
+		t.Error("Adjust modified the image it was applied to")
+	}
+}
+
+func TestScaleDown(t *testing.T) {
+	for _, test := range []struct {
+		name                 string
+		w, h, maxW, maxH     int32
+		expectedW, expectedH int32
+	}{
+		{"landscape", 400, 100, 200, 200, 200, 50},
+		// Smaller in area than the target, but taller
+		{"portrait", 50, 400, 200, 200, 25, 200},
+		{"both too large", 300, 600, 200, 200, 100, 200},
+		{"small", 100, 50, 200, 200, 100, 50},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			src := readTestImage(t, image.NewGray(image.Rect(0, 0,
+				int(test.w), int(test.h))))
+			img := src.ScaleDown(test.maxW, test.maxH)
+
+			w, h, _ := img.Dimensions()
+			if w != test.expectedW || h != test.expectedH {
+				t.Errorf("scaled to %dx%d, expected %dx%d", w, h,
+					test.expectedW, test.expectedH)
+			}
+			if w == test.w && h == test.h && img != src {
+				t.Error("image that already fits was copied")
+			}
+		})
+	}
+}
+
+// photoPattern returns an RGB image of smooth gradients with a little noise,
+// which compresses far better as JPEG than as PNG.
+func photoPattern(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	seed := uint32(1)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			seed = seed*1664525 + 1013904223
+			noise := uint8(seed >> 28)
+			img.Set(x, y, color.RGBA{
+				R: uint8(240*x/w) + noise,
+				G: uint8(240*y/h) + noise,
+				B: uint8(240*(x+y)/(w+h)) + noise,
+				A: 0xff,
+			})
+		}
+	}
+	return img
+}
+
+func TestReaderAutoFormat(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "photo.jpg")
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = jpeg.Encode(f, photoPattern(128, 96), nil)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	photo, err := NewImageFromFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// JPEG sources stay JPEG, rather than becoming a much larger PNG
+	auto, format, err := photo.Reader("auto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format != "jpg" {
+		t.Errorf("JPEG stored as %s, expected jpg", format)
+	}
+	asPNG, _, err := photo.Reader("png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auto.Len() >= asPNG.Len() {
+		t.Errorf("JPEG stored in %d bytes, expected fewer than the %d "+
+			"bytes of PNG", auto.Len(), asPNG.Len())
+	}
+
+	// PNGs stay PNG, and formats that gofpdf can't embed (such as PGM)
+	// fall back to PNG
+	for name, img := range map[string]*Image{
+		"PNG": readTestImage(t, photoPattern(128, 96)),
+		"PGM": testPageImage(t),
+	} {
+		if _, format, err := img.Reader("auto"); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if format != "png" {
+			t.Errorf("%s stored as %s, expected png", name, format)
+		}
+	}
+}
+
+func TestNewImageFromGoGray(t *testing.T) {
+	gray16 := image.NewGray16(image.Rect(0, 0, 8, 8))
+	gray16.SetGray16(1, 1, color.Gray16{Y: 0x8000})
+	transparent := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	transparent.SetNRGBA(1, 1, color.NRGBA{R: 0xff, A: 0xff})
+
+	for _, test := range []struct {
+		name  string
+		img   image.Image
+		depth int32
+		// corner is the expected colour of the (unset) top left pixel
+		corner color.RGBA
+	}{
+		{"gray16", gray16, 8, color.RGBA{0, 0, 0, 0xff}},
+		{"paletted", palettedGray(4), 8, color.RGBA{0, 0, 0, 0xff}},
+		{"transparent", transparent, 32,
+			color.RGBA{0xff, 0xff, 0xff, 0xff}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := NewImageFromGo(test.img)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer img.Close()
+			if _, _, depth := img.Dimensions(); depth != test.depth {
+				t.Errorf("image has depth %dbpp, expected %dbpp", depth,
+					test.depth)
+			}
+			goImg, err := img.ToGo()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r, g, b, a := goImg.At(0, 0).RGBA()
+			corner := color.RGBA{uint8(r >> 8), uint8(g >> 8),
+				uint8(b >> 8), uint8(a >> 8)}
+			if corner != test.corner {
+				t.Errorf("corner is %v, expected %v", corner, test.corner)
+			}
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package ocrpdf
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// pdfTextEscaper escapes the characters of PDF string literals.
+var pdfTextEscaper = strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`,
+	"\r", `\r`)
+
+// lineWords splits the given words, in reading order, into lines. A word
+// starts a new line if it doesn't overlap the current line vertically, or is
+// to the left of the previous word.
+func lineWords(words []Word) [][]Word {
+	var lines [][]Word
+	var line []Word
+	var top, bottom int
+	for _, word := range words {
+		if len(line) > 0 && (word.Top >= bottom || word.Bottom <= top ||
+			word.Left < line[len(line)-1].Left) {
+			lines = append(lines, line)
+			line = nil
+		}
+		if len(line) == 0 {
+			top, bottom = word.Top, word.Bottom
+		}
+		line = append(line, word)
+		top, bottom = minInt(top, word.Top), maxInt(bottom, word.Bottom)
+	}
+	if len(line) > 0 {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// lineRun is a line of words laid out as a single run of text.
+type lineRun struct {
+	// text is the TJ array of the words, without its brackets
+	text string
+	// x and baseline are the position of the start of the line, and size
+	// and scale the height of its text and its horizontal scaling
+	x, baseline, size, scale float64
+	// color sets the fill colour of the line in debug mode
+	color string
+}
+
+// layoutLineRun lays out the words of a line as a single run of text, with
+// spaces between the words, and each word positioned at the start of its box
+// using TJ kerning adjustments. In debug mode, the line is outlined. Lines
+// with no height can't be laid out.
+func (d *Document) layoutLineRun(line []Word) (lineRun, bool) {
+	pdf := d.Fpdf
+
+	left, top, bottom := line[0].Left, line[0].Top, line[0].Bottom
+	right := line[0].Right
+	for _, word := range line[1:] {
+		top, bottom = minInt(top, word.Top), maxInt(bottom, word.Bottom)
+		right = maxInt(right, word.Right)
+	}
+	ps := d.pixelSize()
+	h := float64(bottom-top) * ps
+	if h <= 0 {
+		return lineRun{}, false
+	}
+
+	// In debug mode, lines are coloured by their average confidence
+	var color string
+	if d.debug {
+		var confidence float32
+		for _, word := range line {
+			confidence += word.Confidence
+		}
+		r, g, b := confidenceColor(confidence / float32(len(line)))
+
+		// Outline detected line area
+		pdf.SetDrawColor(r, g, b)
+		pdf.Rect(float64(left)*ps, float64(top)*ps, float64(right-left)*ps, h,
+			"D")
+		color = fmt.Sprintf("%.3f %.3f %.3f rg ", float64(r)/255,
+			float64(g)/255, float64(b)/255)
+	}
+
+	// Size font to line height, then scale horizontally so that, on average,
+	// the text is as wide as the words in the image
+	pdf.SetFontUnitSize(h)
+	var natural, actual float64
+	for _, word := range line {
+		natural += pdf.GetStringWidth(word.Text)
+		actual += float64(word.Width) * ps
+	}
+	scale := 1.0
+	if natural > 0 && actual > 0 {
+		scale = actual / natural
+	}
+
+	// Kerning adjustments are in thousandths of the (scaled) font size, and
+	// move the text left, so convert the gap to the start of the next word
+	var run bytes.Buffer
+	x := float64(left) * ps
+	for i, word := range line {
+		text := word.Text
+		if i == len(line)-1 {
+			fmt.Fprintf(&run, "(%s)", pdfTextEscaper.Replace(text))
+			break
+		}
+		text += " "
+		fmt.Fprintf(&run, "(%s) ", pdfTextEscaper.Replace(text))
+		end := x + pdf.GetStringWidth(text)*scale
+		next := float64(line[i+1].Left) * ps
+		fmt.Fprintf(&run, "%.2f ", -(next-end)*1000/(h*scale))
+		x = next
+	}
+
+	// Place baseline where the font's descenders fit within the line
+	return lineRun{
+		text:     run.String(),
+		x:        float64(left) * ps,
+		baseline: float64(bottom)*ps - 0.2*h,
+		size:     h,
+		scale:    scale,
+		color:    color,
+	}, true
+}
+
+// addLineRun adds the words of a line to the page as a single run of text,
+// in a text object of its own.
+func (d *Document) addLineRun(line []Word) {
+	pdf := d.Fpdf
+	run, ok := d.layoutLineRun(line)
+	if !ok {
+		return
+	}
+
+	k := pdf.GetConversionRatio()
+	_, pageHeight := pdf.GetPageSize()
+	text := fmt.Sprintf("BT %.2f Tz %.2f %.2f Td [%s] TJ 100 Tz ET",
+		100*run.scale, run.x*k, (pageHeight-run.baseline)*k, run.text)
+	if run.color != "" {
+		text = "q " + run.color + text + " Q"
+	}
+	pdf.RawWriteStr(text)
+}
+
+// addLines adds the given lines of words to the page as a single text
+// object, in reading order, each laid out as a run of text (see
+// layoutLineRun). Each line is started with its own text matrix (Tm), which
+// sizes, scales and positions it, so the end of the previous line is an
+// explicit line break, and extracted text has a line break between lines.
+func (d *Document) addLines(lines [][]Word) {
+	pdf := d.Fpdf
+
+	var runs []lineRun
+	for _, line := range lines {
+		if run, ok := d.layoutLineRun(line); ok {
+			runs = append(runs, run)
+		}
+	}
+	if len(runs) == 0 {
+		return
+	}
+
+	// The font is one point in size, as the text matrix of each line scales
+	// it to the height of the line
+	pdf.SetFontSize(1)
+	k := pdf.GetConversionRatio()
+	_, pageHeight := pdf.GetPageSize()
+	var text bytes.Buffer
+	text.WriteString("q BT")
+	for _, run := range runs {
+		fmt.Fprintf(&text, " %s%.2f 0 0 %.2f %.2f %.2f Tm [%s] TJ",
+			run.color, run.size*run.scale*k, run.size*k, run.x*k,
+			(pageHeight-run.baseline)*k, run.text)
+	}
+	text.WriteString(" ET Q")
+	pdf.RawWriteStr(text.String())
+}
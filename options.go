@@ -0,0 +1,268 @@
+package ocrpdf
+
+// Options holds the settings used to configure a new Document. See the
+// corresponding Document setters for details of each.
+type Options struct {
+	Debug          bool
+	Orientation    Orientation
+	TextScaling    TextScaling
+	TextLayout     TextLayout
+	RotateWords    bool
+	MinConfidence  float32
+	FontFamily     string
+	FontFile       string
+	FontStyle      string
+	FontSize       float64
+	AutoFontSize   bool
+	PreserveStyles bool
+	Compression    bool
+	ImageFormat    string
+	JPEGQuality    int
+	Progressive    bool
+	ImageHook      ImageHook
+	Contrast       float64
+	DPI            int
+	SourceDPI      int
+	SmoothScaling  bool
+	FitAspect      float64
+	BoxPadding     float64
+	Margins        [4]float64 // left, top, right, bottom
+	ImageFit       ImageFit
+	Zoom           string
+	Layout         string
+	PDFA           string
+	Encrypt        bool
+	UserPassword   string
+	OwnerPassword  string
+	Permissions    int
+
+	// PageSize, DataPath and Language are only used by Convert, which
+	// creates the document and the Tess instance itself
+	PageSize string
+	DataPath string
+	Language string
+}
+
+// DefaultOptions returns the settings used by NewDocumentWithOptions before
+// any options are applied.
+func DefaultOptions() Options {
+	return Options{
+		Orientation: AutoOrientation,
+		TextScaling: MatchTextScaling,
+		TextLayout:  CellTextLayout,
+		ImageFit:    PageImageFit,
+		FontFamily:  "Arial",
+		FontSize:    10,
+		Compression: true,
+		ImageFormat: "jpeg",
+		Contrast:    0.5,
+		Zoom:        "fit",
+		Layout:      "single",
+		PageSize:    "a4",
+	}
+}
+
+// Option modifies the settings of a new Document.
+type Option func(*Options)
+
+// NewDocumentWithOptions returns a new Document of the specified size,
+// starting from DefaultOptions and applying each of the given options in
+// turn, e.g.
+//
+//	doc := NewDocumentWithOptions("a4",
+//		WithFont("Times", "", 12),
+//		WithImageFormat("png"),
+//		WithDPI(300))
+func NewDocumentWithOptions(size string, opts ...Option) *Document {
+	o := DefaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	d := NewDocument(size)
+	d.SetDebug(o.Debug)
+	d.SetOrientation(o.Orientation)
+	d.SetTextScaling(o.TextScaling)
+	d.SetTextLayout(o.TextLayout)
+	d.SetMinConfidence(o.MinConfidence)
+	d.SetRotateWords(o.RotateWords)
+	if o.FontFile != "" {
+		d.SetUnicodeFont(o.FontFamily, o.FontFile)
+	}
+	d.SetFont(o.FontFamily, o.FontStyle, o.FontSize)
+	d.SetAutoFontSize(o.AutoFontSize)
+	d.SetPreserveStyles(o.PreserveStyles)
+	d.SetCompression(o.Compression)
+	d.SetImageFormat(o.ImageFormat)
+	d.SetJPEGQuality(o.JPEGQuality, o.Progressive)
+	d.SetImageHook(o.ImageHook)
+	d.SetContrast(o.Contrast)
+	d.SetDPI(o.DPI)
+	d.SetSourceDPI(o.SourceDPI)
+	d.SetSmoothScaling(o.SmoothScaling)
+	d.SetFitAspect(o.FitAspect)
+	d.SetBoxPadding(o.BoxPadding)
+	d.SetPageMargins(o.Margins[0], o.Margins[1], o.Margins[2], o.Margins[3])
+	d.SetImageFit(o.ImageFit)
+	d.SetDisplayMode(o.Zoom, o.Layout)
+	if o.Encrypt {
+		d.SetEncryption(o.UserPassword, o.OwnerPassword, o.Permissions)
+	}
+	d.SetPDFA(o.PDFA)
+	return d
+}
+
+// WithOptions replaces all settings with those given.
+func WithOptions(options Options) Option {
+	return func(o *Options) { *o = options }
+}
+
+// WithDebug enables or disables debug mode.
+func WithDebug(enabled bool) Option {
+	return func(o *Options) { o.Debug = enabled }
+}
+
+// WithOrientation sets the orientation of new pages.
+func WithOrientation(orientation Orientation) Option {
+	return func(o *Options) { o.Orientation = orientation }
+}
+
+// WithTextScaling sets the text scaling mode.
+func WithTextScaling(mode TextScaling) Option {
+	return func(o *Options) { o.TextScaling = mode }
+}
+
+// WithMinConfidence sets the minimum confidence of words in the text layer.
+func WithMinConfidence(confidence float32) Option {
+	return func(o *Options) { o.MinConfidence = confidence }
+}
+
+// WithTextLayout sets the text layout.
+func WithTextLayout(layout TextLayout) Option {
+	return func(o *Options) { o.TextLayout = layout }
+}
+
+// WithRotateWords enables or disables the rotation of angled words.
+func WithRotateWords(enabled bool) Option {
+	return func(o *Options) { o.RotateWords = enabled }
+}
+
+// WithFont sets the font used for the text layer.
+func WithFont(family, style string, size float64) Option {
+	return func(o *Options) {
+		o.FontFamily, o.FontStyle, o.FontSize = family, style, size
+	}
+}
+
+// WithFontFile embeds the TrueType font in fontFile for the text layer, under
+// the family name given to WithFont.
+func WithFontFile(fontFile string) Option {
+	return func(o *Options) { o.FontFile = fontFile }
+}
+
+// WithAutoFontSize enables or disables sizing the font to each word.
+func WithAutoFontSize(enabled bool) Option {
+	return func(o *Options) { o.AutoFontSize = enabled }
+}
+
+// WithPreserveStyles enables or disables matching the style of each word.
+func WithPreserveStyles(enabled bool) Option {
+	return func(o *Options) { o.PreserveStyles = enabled }
+}
+
+// WithCompression enables or disables document compression.
+func WithCompression(enabled bool) Option {
+	return func(o *Options) { o.Compression = enabled }
+}
+
+// WithImageFormat sets the format used to store images.
+func WithImageFormat(format string) Option {
+	return func(o *Options) { o.ImageFormat = format }
+}
+
+// WithJPEGQuality sets the quality of images stored as JPEGs (0 = the
+// package default), and whether they are progressive.
+func WithJPEGQuality(quality int, progressive bool) Option {
+	return func(o *Options) {
+		o.JPEGQuality, o.Progressive = quality, progressive
+	}
+}
+
+// WithImageHook sets the function called with each embedded image.
+func WithImageHook(hook ImageHook) Option {
+	return func(o *Options) { o.ImageHook = hook }
+}
+
+// WithContrast sets the amount of contrast enhancement (0 = disabled).
+func WithContrast(amount float64) Option {
+	return func(o *Options) { o.Contrast = amount }
+}
+
+// WithDPI sets the resolution images are scaled down to (0 = disabled).
+func WithDPI(dpi int) Option {
+	return func(o *Options) { o.DPI = dpi }
+}
+
+// WithSourceDPI sets the resolution of the images given to AddPage.
+func WithSourceDPI(dpi int) Option {
+	return func(o *Options) { o.SourceDPI = dpi }
+}
+
+// WithSmoothScaling enables or disables smooth scaling of embedded images.
+func WithSmoothScaling(enabled bool) Option {
+	return func(o *Options) { o.SmoothScaling = enabled }
+}
+
+// WithFitAspect sets the aspect ratio above which pages are sized to fit the
+// image (0 = disabled).
+func WithFitAspect(ratio float64) Option {
+	return func(o *Options) { o.FitAspect = ratio }
+}
+
+// WithBoxPadding sets the fraction of word height by which word boxes are
+// expanded.
+func WithBoxPadding(padding float64) Option {
+	return func(o *Options) { o.BoxPadding = padding }
+}
+
+// WithPageMargins sets the space left between each page's image and edges.
+func WithPageMargins(left, top, right, bottom float64) Option {
+	return func(o *Options) { o.Margins = [4]float64{left, top, right, bottom} }
+}
+
+// WithImageFit sets how each page's image is placed on it.
+func WithImageFit(fit ImageFit) Option {
+	return func(o *Options) { o.ImageFit = fit }
+}
+
+// WithPDFA sets the PDF/A level the document must conform to.
+func WithPDFA(level string) Option {
+	return func(o *Options) { o.PDFA = level }
+}
+
+// WithEncryption encrypts the document with the given passwords, granting
+// the given permissions to users without the owner password.
+func WithEncryption(userPwd, ownerPwd string, perms int) Option {
+	return func(o *Options) {
+		o.Encrypt = true
+		o.UserPassword, o.OwnerPassword = userPwd, ownerPwd
+		o.Permissions = perms
+	}
+}
+
+// WithPageSize sets the page size of documents created by Convert.
+func WithPageSize(size string) Option {
+	return func(o *Options) { o.PageSize = size }
+}
+
+// WithLanguage sets the directory of the language data (or "" for
+// Tesseract's default directory) and the language used by Convert.
+func WithLanguage(datapath, language string) Option {
+	return func(o *Options) { o.DataPath, o.Language = datapath, language }
+}
+
+// WithDisplayMode sets the zoom and page layout used when the document is
+// opened.
+func WithDisplayMode(zoom, layout string) Option {
+	return func(o *Options) { o.Zoom, o.Layout = zoom, layout }
+}
@@ -0,0 +1,84 @@
+package ocrpdf
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jung-kurt/gofpdf"
+)
+
+// pageSizeHint describes the valid page sizes, for errors.
+const pageSizeHint = "give a name such as A4, or WIDTHxHEIGHT followed by " +
+	"mm, cm, in or pt, e.g. 210x297mm"
+
+// pageSizePattern matches explicit page sizes, such as "210x297mm".
+var pageSizePattern = regexp.MustCompile(
+	`^([0-9]*\.?[0-9]+)\s*[x×]\s*([0-9]*\.?[0-9]+)\s*([a-z]+)$`)
+
+// mmPerUnit is the number of millimetres in each unit of explicit page sizes.
+var mmPerUnit = map[string]float64{
+	"mm": 1,
+	"cm": 10,
+	"in": mmPerInch,
+	"pt": mmPerInch / 72,
+}
+
+// ParsePageSize parses an explicit page size, given as WIDTHxHEIGHT followed
+// by a unit of mm, cm, in or pt, e.g. "210x297mm" or "8.5x14in". The size is
+// returned in millimetres. Returns false if the size is a name (such as "A4"),
+// rather than explicit, or an error if it's explicit but invalid.
+func ParsePageSize(size string) (gofpdf.SizeType, bool, error) {
+	// Names (such as "A4") never start with a number
+	size = strings.ToLower(strings.TrimSpace(size))
+	if size == "" || !strings.ContainsAny(size[:1], "0123456789.") {
+		return gofpdf.SizeType{}, false, nil
+	}
+
+	m := pageSizePattern.FindStringSubmatch(size)
+	if m == nil {
+		return gofpdf.SizeType{}, true, fmt.Errorf("invalid page size '%s': "+
+			"%s", size, pageSizeHint)
+	}
+	scale, ok := mmPerUnit[m[3]]
+	if !ok {
+		return gofpdf.SizeType{}, true, fmt.Errorf("invalid unit '%s' in "+
+			"page size '%s': use mm, cm, in or pt", m[3], size)
+	}
+	w, _ := strconv.ParseFloat(m[1], 64)
+	h, _ := strconv.ParseFloat(m[2], 64)
+	w, h = w*scale, h*scale
+	if w <= 0 || h <= 0 || w > maxPageLength || h > maxPageLength {
+		return gofpdf.SizeType{}, true, fmt.Errorf("invalid page size '%s': "+
+			"each edge must be between 0 and %gmm", size,
+			float64(maxPageLength))
+	}
+	return gofpdf.SizeType{Wd: w, Ht: h}, true, nil
+}
+
+// newFpdf returns a new Fpdf, measured in millimetres, whose default pages
+// are of the given size (see NewDocument). If the size is invalid, an A4
+// Fpdf is returned with its error set.
+func newFpdf(size string) *gofpdf.Fpdf {
+	dims, explicit, err := ParsePageSize(size)
+	if explicit {
+		pdf := gofpdf.NewCustom(&gofpdf.InitType{
+			OrientationStr: "P",
+			UnitStr:        "mm",
+			Size:           dims,
+		})
+		if err != nil {
+			pdf.SetError(err)
+		}
+		return pdf
+	}
+
+	pdf := gofpdf.New("P", "mm", size, "")
+	if err := pdf.Error(); err != nil {
+		// Explain the alternatives to unknown names
+		pdf = gofpdf.New("P", "mm", "A4", "")
+		pdf.SetErrorf("%s: %s", err, pageSizeHint)
+	}
+	return pdf
+}
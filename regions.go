@@ -0,0 +1,110 @@
+package ocrpdf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Region describes a named area of a page to be recognised separately, such
+// as a field on a form.
+type Region struct {
+	Name      string
+	Left      int
+	Top       int
+	Width     int
+	Height    int
+	Whitelist string
+}
+
+// ReadRegions parses a list of regions, one per line, in the form:
+//
+//	name left,top,width,height [whitelist]
+//
+// Blank lines and lines beginning with '#' are ignored. If whitelist is
+// omitted, recognition within the region is unrestricted.
+func ReadRegions(r io.Reader) ([]Region, error) {
+	var regions []Region
+
+	scanner := bufio.NewScanner(r)
+	lineno := 0
+	for scanner.Scan() {
+		lineno++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 || len(fields) > 3 {
+			return nil, fmt.Errorf("line %d: expected 'name "+
+				"left,top,width,height [whitelist]'", lineno)
+		}
+
+		dims := strings.Split(fields[1], ",")
+		if len(dims) != 4 {
+			return nil, fmt.Errorf("line %d: expected 4 dimensions, got %d",
+				lineno, len(dims))
+		}
+		var values [4]int
+		for i, dim := range dims {
+			v, err := strconv.Atoi(dim)
+			if err != nil || v < 0 {
+				return nil, fmt.Errorf("line %d: invalid dimension '%s'",
+					lineno, dim)
+			}
+			values[i] = v
+		}
+
+		region := Region{
+			Name:   fields[0],
+			Left:   values[0],
+			Top:    values[1],
+			Width:  values[2],
+			Height: values[3],
+		}
+		if len(fields) == 3 {
+			region.Whitelist = fields[2]
+		}
+		regions = append(regions, region)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return regions, nil
+}
+
+// RegionWords performs a separate recognition pass over each of the given
+// regions of the current image, restricting the recognised characters to
+// the region's whitelist, and returns the words found keyed by region name.
+// The whitelist is restored afterwards, but the recognition rectangle remains
+// until a new image is set, so this should be called after recognising the
+// full page.
+func (t *Tess) RegionWords(regions []Region) (map[string][]Word, error) {
+	whitelist, _ := t.StringVariable("tessedit_char_whitelist")
+	defer t.SetVariable("tessedit_char_whitelist", whitelist)
+
+	words := make(map[string][]Word, len(regions))
+	for _, region := range regions {
+		regionWhitelist := region.Whitelist
+		if regionWhitelist == "" {
+			regionWhitelist = whitelist
+		}
+		err := t.SetVariable("tessedit_char_whitelist", regionWhitelist)
+		if err != nil {
+			return nil, err
+		}
+		t.SetRectangle(region.Left, region.Top, region.Width, region.Height)
+		regionWords, err := t.Words()
+		if err != nil {
+			return nil, err
+		}
+		words[region.Name] = append(words[region.Name], regionWords...)
+	}
+
+	return words, nil
+}
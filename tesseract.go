@@ -5,24 +5,161 @@ package ocrpdf
 // #cgo LDFLAGS: -ltesseract
 // #include "tesseract/capi.h"
 // #include <stdlib.h>
+//
+// // TessBaseAPIGetAltoText was added in Tesseract 4.1, so is linked weakly
+// // to allow building with earlier versions, which lack it
+// char *TessBaseAPIGetAltoText(TessBaseAPI *handle, int page_number);
+// #pragma weak TessBaseAPIGetAltoText
+//
+// static int hasAltoText(void) {
+// 	return TessBaseAPIGetAltoText != NULL;
+// }
 import "C"
 import (
+	"context"
 	"errors"
+	"fmt"
+	"math"
+	"os"
 	"runtime"
+	"sort"
+	"strings"
 	"unsafe"
 )
 
+// The range of image resolutions, in DPI, within which Tesseract recognises
+// text reliably.
+const (
+	MinReliableResolution = 70
+	MaxReliableResolution = 2400
+)
+
+// ErrNoImage is returned when recognition is attempted before an image has
+// been set with SetImagePix.
+var ErrNoImage = errors.New("no image has been set for recognition")
+
 type Word struct {
-	Text   string
-	Left   int
-	Right  int
-	Top    int
-	Bottom int
-	Width  int
-	Height int
+	Text   string `json:"text"`
+	Left   int    `json:"left"`
+	Right  int    `json:"right"`
+	Top    int    `json:"top"`
+	Bottom int    `json:"bottom"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+
+	// Baseline runs from (BaselineX1, BaselineY1) to (BaselineX2, BaselineY2)
+	BaselineX1 int `json:"baseline_x1"`
+	BaselineY1 int `json:"baseline_y1"`
+	BaselineX2 int `json:"baseline_x2"`
+	BaselineY2 int `json:"baseline_y2"`
+
+	// Confidence is Tesseract's confidence in the word, from 0 to 100
+	Confidence float32 `json:"confidence"`
+
+	// Font attributes, where supported by the recognition engine
+	Bold      bool `json:"bold"`
+	Italic    bool `json:"italic"`
+	Serif     bool `json:"serif"`
+	Monospace bool `json:"monospace"`
+	PointSize int  `json:"point_size"`
+}
+
+// Angle returns the angle of the word's baseline in degrees, measured
+// anti-clockwise from the horizontal.
+func (w Word) Angle() float64 {
+	dx := float64(w.BaselineX2 - w.BaselineX1)
+	dy := float64(w.BaselineY1 - w.BaselineY2)
+	if dx == 0 && dy == 0 {
+		return 0
+	}
+	return math.Atan2(dy, dx) * 180 / math.Pi
 }
 
+// ScaleWords returns a copy of the given words with all coordinates
+// multiplied by factor, for use with an image scaled by the same amount.
+func ScaleWords(words []Word, factor float64) []Word {
+	scale := func(v int) int {
+		return int(math.Floor(float64(v)*factor + 0.5))
+	}
+	scaled := make([]Word, len(words))
+	for i, w := range words {
+		w.Left, w.Right = scale(w.Left), scale(w.Right)
+		w.Top, w.Bottom = scale(w.Top), scale(w.Bottom)
+		w.Width, w.Height = scale(w.Width), scale(w.Height)
+		w.BaselineX1, w.BaselineY1 = scale(w.BaselineX1), scale(w.BaselineY1)
+		w.BaselineX2, w.BaselineY2 = scale(w.BaselineX2), scale(w.BaselineY2)
+		scaled[i] = w
+	}
+	return scaled
+}
+
+// EngineMode selects the recognition engine(s) that Tesseract uses.
+type EngineMode int
+
+const (
+	// LegacyEngine uses only the original Tesseract engine.
+	LegacyEngine EngineMode = C.OEM_TESSERACT_ONLY
+	// LSTMEngine uses only the neural net (LSTM) engine, which is the most
+	// accurate for most modern scans.
+	LSTMEngine EngineMode = C.OEM_LSTM_ONLY
+	// CombinedEngine uses both engines, combining their results.
+	CombinedEngine EngineMode = C.OEM_TESSERACT_LSTM_COMBINED
+	// DefaultEngine uses whichever engine(s) the language data supports.
+	DefaultEngine EngineMode = C.OEM_DEFAULT
+)
+
+// NewTess creates a new Tess instance using the default engine mode, with
+// the language data in the given directory (or Tesseract's default directory
+// if empty).
 func NewTess(datapath string, language string) (*Tess, error) {
+	return NewTessWithMode(datapath, language, DefaultEngine)
+}
+
+// NewTessWithMode creates a new Tess instance as per NewTess, using the given
+// engine mode. The language data must support the engine(s) used.
+func NewTessWithMode(datapath string, language string, mode EngineMode) (
+	*Tess, error) {
+	return NewTessWithVariables(datapath, language, mode, nil)
+}
+
+// fileVariables are the Tesseract variables that name files, which Tesseract
+// silently ignores if they can't be read.
+var fileVariables = []string{"user_words_file", "user_patterns_file"}
+
+// NewTessWithVariables creates a new Tess instance as per NewTessWithMode,
+// setting the given Tesseract variables as it is initialised. Some
+// variables, such as those below, are only read during initialisation, so
+// can't be set later with SetVariable:
+//
+//	user_words_file     a file of extra words (such as jargon), one per line
+//	user_patterns_file  a file of patterns that words may match, e.g. \d\d\d
+//
+// An error is returned if any of these files don't exist, rather than being
+// ignored by Tesseract.
+func NewTessWithVariables(datapath string, language string, mode EngineMode,
+	variables map[string]string) (*Tess, error) {
+	for _, name := range fileVariables {
+		if fn, ok := variables[name]; ok {
+			if _, err := os.Stat(fn); err != nil {
+				return nil, fmt.Errorf("could not use %s: %s", name, err)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cNames := make([]*C.char, len(names)+1)
+	cValues := make([]*C.char, len(names)+1)
+	for i, name := range names {
+		cNames[i] = C.CString(name)
+		defer C.free(unsafe.Pointer(cNames[i]))
+		cValues[i] = C.CString(variables[name])
+		defer C.free(unsafe.Pointer(cValues[i]))
+	}
+
 	api := C.TessBaseAPICreate()
 
 	var cDatapath *C.char
@@ -37,8 +174,11 @@ func NewTess(datapath string, language string) (*Tess, error) {
 	}
 	defer C.free(unsafe.Pointer(cLanguage))
 
-	res := C.TessBaseAPIInit3(api, cDatapath, cLanguage)
+	res := C.TessBaseAPIInit4(api, cDatapath, cLanguage,
+		C.TessOcrEngineMode(mode), nil, 0, &cNames[0], &cValues[0],
+		C.size_t(len(names)), 0)
 	if res != 0 {
+		C.TessBaseAPIDelete(api)
 		return nil, errors.New("could not initiate new Tess instance")
 	}
 
@@ -51,55 +191,490 @@ func NewTess(datapath string, language string) (*Tess, error) {
 	return tess, nil
 }
 
+// LanguagePolicy restricts the languages that Tess instances may be created
+// with, such as in a service that accepts languages from its users, to
+// prevent uninstalled or undesired languages from being requested.
+type LanguagePolicy struct {
+	// Allowed lists the permitted languages (e.g. "eng", "fra")
+	Allowed []string
+	// Default is the language used if none of those requested are allowed
+	Default string
+}
+
+// Sanitize returns the given language specification (such as "eng+fra")
+// with any languages that aren't allowed removed, or the default language if
+// none of them are allowed.
+func (p LanguagePolicy) Sanitize(language string) string {
+	var allowed []string
+	for _, lang := range strings.Split(language, "+") {
+		lang = strings.TrimSpace(lang)
+		for _, a := range p.Allowed {
+			if lang == a {
+				allowed = append(allowed, lang)
+				break
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		return p.Default
+	}
+	return strings.Join(allowed, "+")
+}
+
+// NewTess creates a new Tess instance as per NewTess, after sanitizing the
+// requested language according to the policy.
+func (p LanguagePolicy) NewTess(datapath string, language string) (*Tess,
+	error) {
+	return NewTess(datapath, p.Sanitize(language))
+}
+
+// Tess is an instance of the Tesseract API, loaded with the language data of
+// one or more languages. Loading language data is slow, so a single Tess
+// should be reused for many images: each call to SetImagePix discards the
+// results of the previous image. However, the legacy engine also adapts to
+// the fonts it has seen, so call Clear between very different images to
+// stop this from affecting their recognition. A Tess must not be used by
+// more than one goroutine at a time.
 type Tess struct {
-	api *C.TessBaseAPI
+	api      *C.TessBaseAPI
+	hasImage bool
 }
 
 func (t *Tess) delete() {
 	if t.api != nil {
 		C.TessBaseAPIEnd(t.api)
 		C.TessBaseAPIDelete(t.api)
+		t.api = nil
+		t.hasImage = false
 	}
 }
 
-// SetImagePix sets the image to perform recognition on
+// Close frees the instance and its language data immediately, rather than
+// when it is garbage collected. The instance must not be used afterwards, but
+// closing it again is harmless.
+func (t *Tess) Close() error {
+	runtime.SetFinalizer(t, nil)
+	t.delete()
+	return nil
+}
+
+// SetImagePix sets the image to perform recognition on. Setting a nil image
+// clears the current image.
 func (t *Tess) SetImagePix(pix *C.struct_Pix) {
+	if pix == nil {
+		C.TessBaseAPIClear(t.api)
+		t.hasImage = false
+		return
+	}
 	C.TessBaseAPISetImage2(t.api, pix)
+	t.hasImage = true
+}
+
+// Clear resets the instance to its state after initialisation, without
+// reloading the language data. The current image and its results are
+// discarded, along with anything the legacy engine has learnt about the
+// fonts of previous images.
+func (t *Tess) Clear() {
+	C.TessBaseAPIClear(t.api)
+	C.TessBaseAPIClearAdaptiveClassifier(t.api)
+	t.hasImage = false
+}
+
+// HasImage returns true if an image has been set for recognition.
+func (t *Tess) HasImage() bool {
+	return t.hasImage
+}
+
+// SetSourceResolution sets the resolution of the current image, in DPI,
+// overriding any resolution recorded in the image itself. It must be called
+// after SetImagePix.
+func (t *Tess) SetSourceResolution(ppi int) {
+	C.TessBaseAPISetSourceResolution(t.api, C.int(ppi))
+}
+
+// SourceResolution returns the resolution of the current image, in DPI, as
+// used for recognition. This is either the resolution recorded in the image
+// or set by SetSourceResolution, or if neither, Tesseract's own estimate
+// (which is only made once recognition has taken place).
+func (t *Tess) SourceResolution() int {
+	return int(C.TessBaseAPIGetSourceYResolution(t.api))
+}
+
+// SetVariable sets the value of a Tesseract configuration variable, such as
+// `tessedit_char_whitelist`.
+func (t *Tess) SetVariable(name, value string) error {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+
+	if C.TessBaseAPISetVariable(t.api, cName, cValue) == 0 {
+		return fmt.Errorf("could not set Tesseract variable '%s'", name)
+	}
+	return nil
+}
+
+// SetPageSegMode sets how Tesseract segments the image into blocks, lines and
+// words, as per Tesseract's page segmentation modes (PSM), such as 6 for a
+// single uniform block of text, or 11 for sparse text in no particular order.
+// Returns an error if the mode is invalid.
+func (t *Tess) SetPageSegMode(mode int) error {
+	if mode < 0 || mode >= int(C.PSM_COUNT) {
+		return fmt.Errorf("invalid page segmentation mode %d", mode)
+	}
+	C.TessBaseAPISetPageSegMode(t.api, C.TessPageSegMode(mode))
+	return nil
+}
+
+// WordSpacing defines presets for how readily Tesseract splits text into
+// words at the gaps between characters.
+type WordSpacing string
+
+const (
+	// TightWordSpacing treats smaller gaps as spaces, for tightly spaced
+	// text whose words are wrongly joined together.
+	TightWordSpacing WordSpacing = "tight"
+	// NormalWordSpacing uses Tesseract's defaults.
+	NormalWordSpacing = "normal"
+	// LooseWordSpacing requires larger gaps for spaces, for loosely spaced
+	// text whose words are wrongly split apart.
+	LooseWordSpacing = "loose"
+)
+
+// wordSpacingVariables holds the values of the Tesseract variables set for
+// each word spacing preset:
+//
+//   - tosp_min_sane_kn_sp is the minimum ratio of the space and kerning
+//     (character gap) sizes that is trusted; below this, gaps are estimated.
+//   - tosp_threshold_bias2 shifts the threshold between kerning and spaces
+//     towards the size of spaces (0-1).
+//
+// These and other tosp_* variables can also be set directly with SetVariable.
+var wordSpacingVariables = map[WordSpacing][][2]string{
+	TightWordSpacing: {
+		{"tosp_min_sane_kn_sp", "1.0"},
+		{"tosp_threshold_bias2", "0"},
+	},
+	NormalWordSpacing: {
+		{"tosp_min_sane_kn_sp", "1.5"},
+		{"tosp_threshold_bias2", "0"},
+	},
+	LooseWordSpacing: {
+		{"tosp_min_sane_kn_sp", "2.5"},
+		{"tosp_threshold_bias2", "0.3"},
+	},
+}
+
+// SetWordSpacing sets how readily text is split into words, using the given
+// preset. Returns an error if the preset is unknown.
+func (t *Tess) SetWordSpacing(spacing WordSpacing) error {
+	variables, ok := wordSpacingVariables[spacing]
+	if !ok {
+		return fmt.Errorf("unknown word spacing '%s'", spacing)
+	}
+	for _, v := range variables {
+		if err := t.SetVariable(v[0], v[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StringVariable returns the value of a Tesseract configuration variable
+// holding a string, such as `tessedit_char_whitelist`, and whether the
+// variable exists.
+func (t *Tess) StringVariable(name string) (string, bool) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	cValue := C.TessBaseAPIGetStringVariable(t.api, cName)
+	if cValue == nil {
+		return "", false
+	}
+	return C.GoString(cValue), true
+}
+
+// SetRectangle restricts recognition to the given area, in pixels, of the
+// image, which must already have been set with SetImagePix. The rectangle is
+// reset whenever a new image is set; to recognise the whole image again,
+// set a rectangle covering all of it. Tesseract reports the boxes of words
+// in the rectangle relative to the whole image, not the rectangle, so
+// words recognised this way can be passed to Document.AddPage along with
+// the whole image without translating them. See also RegionWords.
+func (t *Tess) SetRectangle(left, top, width, height int) {
+	C.TessBaseAPISetRectangle(t.api, C.int(left), C.int(top),
+		C.int(width), C.int(height))
+}
+
+// Text analyses the document and returns all of the recognised text, with
+// lines and paragraphs separated by newlines. This is simpler than joining
+// the text of Words, and keeps Tesseract's reading order. Returns ErrNoImage
+// if no image has been set.
+func (t *Tess) Text() (string, error) {
+	if !t.hasImage {
+		return "", ErrNoImage
+	}
+	cText := C.TessBaseAPIGetUTF8Text(t.api)
+	if cText == nil {
+		return "", errors.New("text recognition failed")
+	}
+	defer C.TessDeleteText(cText)
+	return C.GoString(cText), nil
+}
+
+// DetectOrientation detects the orientation of the text in the current
+// image, returning the number of 90 degree clockwise rotations (0-3) needed
+// to make the text upright, and Tesseract's confidence in the orientation.
+// This requires the orientation and script detection data (osd.traineddata)
+// to be installed. Returns ErrNoImage if no image has been set.
+func (t *Tess) DetectOrientation() (rotations int, confidence float32,
+	err error) {
+	if !t.hasImage {
+		return 0, 0, ErrNoImage
+	}
+
+	var cDegrees C.int
+	var cConfidence, cScriptConfidence C.float
+	var cScript *C.char
+	if C.TessBaseAPIDetectOrientationScript(t.api, &cDegrees, &cConfidence,
+		&cScript, &cScriptConfidence) == 0 {
+		return 0, 0, errors.New("could not detect orientation (is " +
+			"osd.traineddata installed?)")
+	}
+
+	// The orientation is that of the page, so must be reversed to correct it
+	rotations = ((360 - int(cDegrees)) % 360) / 90
+	return rotations, float32(cConfidence), nil
+}
+
+// HOCRText returns the recognised text of the current image as hOCR, an
+// HTML-based format that describes the layout of the text. The text is
+// enclosed in a div of class "ocr_page", with elements numbered according to
+// the given (zero-based) page number, and should be embedded in a complete
+// HTML document. Returns ErrNoImage if no image has been set.
+func (t *Tess) HOCRText(pageNumber int) (string, error) {
+	if !t.hasImage {
+		return "", ErrNoImage
+	}
+	cText := C.TessBaseAPIGetHOCRText(t.api, C.int(pageNumber))
+	if cText == nil {
+		return "", errors.New("could not produce hOCR text")
+	}
+	defer C.TessDeleteText(cText)
+	return C.GoString(cText), nil
+}
+
+// ALTOText returns the recognised text of the current image as ALTO XML, the
+// format used by digital library systems. The text is enclosed in a Page
+// element numbered according to the given (zero-based) page number, and
+// should be embedded in a complete ALTO document. Coordinates are in the
+// pixels of the current image. Returns ErrNoImage if no image has been set,
+// or an error if the Tesseract library is too old (before 4.1) to produce
+// ALTO.
+func (t *Tess) ALTOText(pageNumber int) (string, error) {
+	if C.hasAltoText() == 0 {
+		return "", fmt.Errorf("ALTO output requires Tesseract 4.1 or "+
+			"later, but %s is installed", C.GoString(C.TessVersion()))
+	}
+	if !t.hasImage {
+		return "", ErrNoImage
+	}
+	cText := C.TessBaseAPIGetAltoText(t.api, C.int(pageNumber))
+	if cText == nil {
+		return "", errors.New("could not produce ALTO text")
+	}
+	defer C.TessDeleteText(cText)
+	return C.GoString(cText), nil
+}
+
+// Line is a line of recognised text.
+type Line struct {
+	Left   int `json:"left"`
+	Right  int `json:"right"`
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	Words []Word `json:"words"`
+}
+
+// Paragraph is a paragraph of recognised text.
+type Paragraph struct {
+	Left   int `json:"left"`
+	Right  int `json:"right"`
+	Top    int `json:"top"`
+	Bottom int `json:"bottom"`
+	Width  int `json:"width"`
+	Height int `json:"height"`
+
+	Lines []Line `json:"lines"`
 }
 
 // Words analyses the document and returns a list of recognised words.
-func (t *Tess) Words() []Word {
+// Returns ErrNoImage if no image has been set.
+func (t *Tess) Words() ([]Word, error) {
+	lines, err := t.Lines()
+	if err != nil {
+		return nil, err
+	}
+
 	var words []Word
+	for _, line := range lines {
+		words = append(words, line.Words...)
+	}
+	return words, nil
+}
 
-	C.TessBaseAPIRecognize(t.api, nil)
+// WordsContext is Words, abandoning recognition and returning ctx.Err() if
+// ctx is cancelled (or its deadline passes) before recognition completes,
+// such as when the client that requested it has gone away.
+func (t *Tess) WordsContext(ctx context.Context) ([]Word, error) {
+	paragraphs, err := t.paragraphs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var words []Word
+	for _, paragraph := range paragraphs {
+		for _, line := range paragraph.Lines {
+			words = append(words, line.Words...)
+		}
+	}
+	return words, nil
+}
+
+// Lines analyses the document and returns a list of recognised lines, each
+// with the words in it. Returns ErrNoImage if no image has been set.
+func (t *Tess) Lines() ([]Line, error) {
+	paragraphs, err := t.Paragraphs()
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []Line
+	for _, paragraph := range paragraphs {
+		lines = append(lines, paragraph.Lines...)
+	}
+	return lines, nil
+}
+
+// Paragraphs analyses the document and returns a list of recognised
+// paragraphs, each with the lines (and words) in it. Returns ErrNoImage if
+// no image has been set.
+func (t *Tess) Paragraphs() ([]Paragraph, error) {
+	return t.paragraphs(context.Background())
+}
+
+// paragraphs is Paragraphs, cancelling recognition if ctx is done.
+func (t *Tess) paragraphs(ctx context.Context) ([]Paragraph, error) {
+	if !t.hasImage {
+		return nil, ErrNoImage
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	monitor, free := newCancelMonitor(ctx)
+	res := C.TessBaseAPIRecognize(t.api, monitor)
+	free()
+	if res != 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("text recognition failed")
+	}
 
 	ri := C.TessBaseAPIGetIterator(t.api)
+	if ri == nil {
+		// No text found
+		return nil, nil
+	}
 	defer C.TessResultIteratorDelete(ri)
 	pi := C.TessResultIteratorGetPageIterator(ri)
 
-	if ri != nil {
-		for {
-			cWord := C.TessResultIteratorGetUTF8Text(ri, C.RIL_WORD)
-			var cLeft, cTop, cRight, cBottom C.int
-			C.TessPageIteratorBoundingBox(pi, C.RIL_WORD,
-				&cLeft, &cTop, &cRight, &cBottom)
-
-			word := Word{
-				Text:   C.GoString(cWord),
-				Left:   int(cLeft),
-				Right:  int(cRight),
-				Top:    int(cTop),
-				Bottom: int(cBottom),
-				Width:  int(cRight - cLeft),
-				Height: int(cBottom - cTop),
-			}
+	var paragraphs []Paragraph
+	for {
+		if len(paragraphs) == 0 ||
+			C.TessPageIteratorIsAtBeginningOf(pi, C.RIL_PARA) != 0 {
+			left, top, right, bottom := boundingBox(pi, C.RIL_PARA)
+			paragraphs = append(paragraphs, Paragraph{
+				Left: left, Top: top, Right: right, Bottom: bottom,
+				Width: right - left, Height: bottom - top,
+			})
+		}
+		paragraph := &paragraphs[len(paragraphs)-1]
 
-			words = append(words, word)
-			if C.TessPageIteratorNext(pi, C.RIL_WORD) == C.int(0) {
-				break
-			}
+		if len(paragraph.Lines) == 0 ||
+			C.TessPageIteratorIsAtBeginningOf(pi, C.RIL_TEXTLINE) != 0 {
+			left, top, right, bottom := boundingBox(pi, C.RIL_TEXTLINE)
+			paragraph.Lines = append(paragraph.Lines, Line{
+				Left: left, Top: top, Right: right, Bottom: bottom,
+				Width: right - left, Height: bottom - top,
+			})
 		}
+		line := &paragraph.Lines[len(paragraph.Lines)-1]
+
+		line.Words = append(line.Words, currentWord(ri, pi))
+		if C.TessPageIteratorNext(pi, C.RIL_WORD) == C.int(0) {
+			break
+		}
+	}
+
+	return paragraphs, nil
+}
+
+// boundingBox returns the bounding box of the element at the given level at
+// the current position of the iterator.
+func boundingBox(pi *C.TessPageIterator,
+	level C.TessPageIteratorLevel) (left, top, right, bottom int) {
+	var cLeft, cTop, cRight, cBottom C.int
+	C.TessPageIteratorBoundingBox(pi, level, &cLeft, &cTop, &cRight, &cBottom)
+	return int(cLeft), int(cTop), int(cRight), int(cBottom)
+}
+
+// currentWord returns the word at the current position of the iterators.
+func currentWord(ri *C.TessResultIterator, pi *C.TessPageIterator) Word {
+	var text string
+	if cWord := C.TessResultIteratorGetUTF8Text(ri, C.RIL_WORD); cWord != nil {
+		text = C.GoString(cWord)
+		C.TessDeleteText(cWord)
+	}
+
+	left, top, right, bottom := boundingBox(pi, C.RIL_WORD)
+	var cX1, cY1, cX2, cY2 C.int
+	C.TessPageIteratorBaseline(pi, C.RIL_WORD, &cX1, &cY1, &cX2, &cY2)
+
+	word := Word{
+		Text:   text,
+		Left:   left,
+		Right:  right,
+		Top:    top,
+		Bottom: bottom,
+		Width:  right - left,
+		Height: bottom - top,
+
+		BaselineX1: int(cX1),
+		BaselineY1: int(cY1),
+		BaselineX2: int(cX2),
+		BaselineY2: int(cY2),
+
+		Confidence: float32(C.TessResultIteratorConfidence(ri, C.RIL_WORD)),
+	}
+
+	// Font attributes are unavailable with some engines, in which case no
+	// font name is returned
+	var cBold, cItalic, cUnderlined, cMonospace, cSerif, cSmallcaps C.BOOL
+	var cPointSize, cFontID C.int
+	if C.TessResultIteratorWordFontAttributes(ri, &cBold, &cItalic,
+		&cUnderlined, &cMonospace, &cSerif, &cSmallcaps,
+		&cPointSize, &cFontID) != nil {
+		word.Bold = cBold != 0
+		word.Italic = cItalic != 0
+		word.Serif = cSerif != 0
+		word.Monospace = cMonospace != 0
+		word.PointSize = int(cPointSize)
 	}
 
-	return words
+	return word
 }
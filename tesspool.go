@@ -0,0 +1,90 @@
+package ocrpdf
+
+import "sync"
+
+// TessPool manages a bounded set of Tess instances for use by concurrent
+// goroutines. A single Tess must not be used by more than one goroutine at a
+// time, and Tesseract's API offers no way to share loaded language data
+// between instances, so each instance loads its own copy when it is created.
+// The pool amortises that cost by creating instances lazily, only when none
+// are idle, and reusing them thereafter.
+type TessPool struct {
+	newTess func() (*Tess, error)
+	slots   chan struct{}
+
+	mu     sync.Mutex
+	idle   []*Tess
+	closed bool
+}
+
+// NewTessPool returns a pool of at most size Tess instances, each created by
+// calling newTess, e.g.
+//
+//	pool := NewTessPool(4, func() (*Tess, error) {
+//		return NewTess(datapath, language)
+//	})
+func NewTessPool(size int, newTess func() (*Tess, error)) *TessPool {
+	if size < 1 {
+		size = 1
+	}
+	return &TessPool{
+		newTess: newTess,
+		slots:   make(chan struct{}, size),
+	}
+}
+
+// Get returns an idle Tess from the pool, creating a new instance if none are
+// idle. If the pool's instances are all in use, Get blocks until one is
+// returned with Put.
+func (p *TessPool) Get() (*Tess, error) {
+	p.slots <- struct{}{}
+
+	p.mu.Lock()
+	if n := len(p.idle); n > 0 {
+		tess := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		p.mu.Unlock()
+		return tess, nil
+	}
+	p.mu.Unlock()
+
+	tess, err := p.newTess()
+	if err != nil {
+		<-p.slots
+		return nil, err
+	}
+	return tess, nil
+}
+
+// Put returns a Tess obtained from Get to the pool, making it available to
+// other goroutines. Instances returned after the pool is closed are closed.
+func (p *TessPool) Put(tess *Tess) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		tess.Close()
+	} else {
+		p.idle = append(p.idle, tess)
+		p.mu.Unlock()
+	}
+	<-p.slots
+}
+
+// Close closes the pool's idle instances, freeing their language data.
+// Instances still in use are closed when they're returned with Put. The pool
+// may still be used afterwards, but creates a new instance for every Get.
+func (p *TessPool) Close() error {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	var err error
+	for _, tess := range idle {
+		if cerr := tess.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
@@ -0,0 +1,143 @@
+package ocrpdf
+
+import (
+	"math"
+	"strings"
+)
+
+// minVoteOverlap is the minimum overlap (intersection over union) of two
+// words' boxes for VoteWords to consider them the same word.
+const minVoteOverlap = 0.5
+
+// VoteWords merges the words recognised in several passes over the same
+// image, such as with different languages. Where words from different passes
+// overlap, only the word with the highest confidence is kept, whilst words
+// found in just one pass are always kept. Words are returned in the order of
+// the first pass, followed by those only found in later passes.
+func VoteWords(passes ...[]Word) []Word {
+	if len(passes) == 0 {
+		return nil
+	}
+
+	words := append([]Word(nil), passes[0]...)
+	for _, pass := range passes[1:] {
+		found := len(words)
+		for _, word := range pass {
+			// Find best match amongst words found in earlier passes
+			best, bestOverlap := -1, 0.0
+			for i, other := range words[:found] {
+				if overlap := wordOverlap(word, other); overlap > bestOverlap {
+					best, bestOverlap = i, overlap
+				}
+			}
+
+			if best < 0 || bestOverlap < minVoteOverlap {
+				words = append(words, word)
+			} else if word.Confidence > words[best].Confidence {
+				words[best] = word
+			}
+		}
+	}
+	return words
+}
+
+// wordOverlap returns the area of the intersection of the boxes of two words
+// divided by the area of their union, from 0 (disjoint) to 1 (identical).
+func wordOverlap(a, b Word) float64 {
+	left, right := maxInt(a.Left, b.Left), minInt(a.Right, b.Right)
+	top, bottom := maxInt(a.Top, b.Top), minInt(a.Bottom, b.Bottom)
+	if left >= right || top >= bottom {
+		return 0
+	}
+
+	intersection := float64((right - left) * (bottom - top))
+	union := float64(a.Width*a.Height+b.Width*b.Height) - intersection
+	if union <= 0 {
+		return 0
+	}
+	return intersection / union
+}
+
+// FilterWords returns the given words without those whose confidence is
+// below minConfidence, and without any that are empty or only whitespace,
+// along with the number of words that were removed.
+func FilterWords(words []Word, minConfidence float32) ([]Word, int) {
+	kept := make([]Word, 0, len(words))
+	for _, word := range words {
+		if strings.TrimSpace(word.Text) == "" ||
+			word.Confidence < minConfidence {
+			continue
+		}
+		kept = append(kept, word)
+	}
+	return kept, len(words) - len(kept)
+}
+
+// Headings returns the text of the likely headings amongst the given words:
+// those lines (see lineWords) whose words are all at least minPointSize
+// points in size. Font sizes are only recognised by some engines, so no
+// headings are found if all word sizes are unknown (0).
+func Headings(words []Word, minPointSize int) []string {
+	var headings []string
+	for _, line := range lineWords(words) {
+		heading := make([]string, 0, len(line))
+		for _, word := range line {
+			if word.PointSize < minPointSize {
+				heading = nil
+				break
+			}
+			heading = append(heading, word.Text)
+		}
+		if text := strings.TrimSpace(strings.Join(heading, " ")); text != "" {
+			headings = append(headings, text)
+		}
+	}
+	return headings
+}
+
+// padWords returns a copy of the given words with their boxes expanded on
+// each side by the given fraction of their height. A word is never expanded
+// by more than half of the gap between it and its neighbours on the same
+// line, so that the boxes of adjacent words don't overlap.
+func padWords(words []Word, padding float64) []Word {
+	padded := make([]Word, len(words))
+	for i, word := range words {
+		pad := int(math.Floor(float64(word.Height)*padding + 0.5))
+		left, right := pad, pad
+		for j, other := range words {
+			if i == j || other.Bottom <= word.Top || other.Top >= word.Bottom {
+				// Not on the same line
+				continue
+			}
+			if gap := word.Left - other.Right; gap >= 0 && gap/2 < left {
+				left = gap / 2
+			}
+			if gap := other.Left - word.Right; gap >= 0 && gap/2 < right {
+				right = gap / 2
+			}
+		}
+
+		word.Left -= left
+		word.Right += right
+		word.Top -= pad
+		word.Bottom += pad
+		word.Width = word.Right - word.Left
+		word.Height = word.Bottom - word.Top
+		padded[i] = word
+	}
+	return padded
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}